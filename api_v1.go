@@ -1,10 +1,13 @@
 package proxycache
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
+
+	"github.com/huangml/proxycache/cache"
 )
 
 type handlerV1 struct {
@@ -18,6 +21,16 @@ func (h *handlerV1) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.status(w)
 	} else if strings.HasPrefix(r.URL.Path, "/v1/config") {
 		h.config(w, r)
+	} else if strings.HasPrefix(r.URL.Path, "/v1/deadletters") {
+		h.deadLetters(w, r)
+	} else if strings.HasPrefix(r.URL.Path, "/v1/clear") {
+		h.clear(w, r)
+	} else if strings.HasPrefix(r.URL.Path, "/v1/dump") {
+		h.dump(w, r)
+	} else if strings.HasPrefix(r.URL.Path, "/v1/top") {
+		h.top(w, r)
+	} else if strings.HasPrefix(r.URL.Path, "/v1/maintenance") {
+		h.maintenance(w, r)
 	} else {
 		http.NotFound(w, r)
 	}
@@ -41,6 +54,9 @@ func (h *handlerV1) keys(w http.ResponseWriter, r *http.Request) {
 		}
 		ttw, _ := strconv.Atoi(r.URL.Query().Get("ttw"))
 		h.put(w, key, value, int64(ttw))
+	case "DELETE":
+		ttw, _ := strconv.Atoi(r.URL.Query().Get("ttw"))
+		h.delete(w, key, int64(ttw))
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -56,7 +72,19 @@ func (h *handlerV1) get(w http.ResponseWriter, key string) {
 }
 
 func (h *handlerV1) put(w http.ResponseWriter, key string, value []byte, ttw int64) {
-	h.p.Put(key, value, ttw)
+	if err := h.p.Put(key, value, ttw); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+func (h *handlerV1) delete(w http.ResponseWriter, key string, ttw int64) {
+	switch h.p.Delete(key, ttw) {
+	case nil:
+	case ErrDeleteUnsupported:
+		w.WriteHeader(http.StatusNotImplemented)
+	default:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
 }
 
 func (h *handlerV1) status(w http.ResponseWriter) {
@@ -66,11 +94,91 @@ func (h *handlerV1) status(w http.ResponseWriter) {
 func (h *handlerV1) config(w http.ResponseWriter, r *http.Request) {
 	loader, _ := strconv.Atoi(r.URL.Query().Get("loader"))
 	saver, _ := strconv.Atoi(r.URL.Query().Get("saver"))
+	batch, _ := strconv.Atoi(r.URL.Query().Get("batch"))
 	if loader > 0 {
 		h.p.SetLoadMaxProc(loader)
 	}
 	if saver > 0 {
 		h.p.SetSaveProc(saver)
 	}
+	if batch > 0 {
+		h.p.SetSaveMaxBatch(batch)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handlerV1) clear(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	forget, _ := strconv.ParseBool(r.URL.Query().Get("forget"))
+	h.p.Clear(forget)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handlerV1) maintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	enabled, _ := strconv.ParseBool(r.URL.Query().Get("enabled"))
+	expired, _ := strconv.ParseBool(r.URL.Query().Get("expired"))
+	h.p.SetMaintenance(enabled, expired)
 	w.WriteHeader(http.StatusOK)
 }
+
+func (h *handlerV1) dump(w http.ResponseWriter, r *http.Request) {
+	format := cache.DumpJSON
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+	case "csv":
+		format = cache.DumpCSV
+		w.Header().Set("Content-Type", "text/csv")
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	h.p.Dump(w, format)
+}
+
+func (h *handlerV1) top(w http.ResponseWriter, r *http.Request) {
+	n := 10
+	if s := r.URL.Query().Get("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.p.TopKeys(n))
+}
+
+func (h *handlerV1) deadLetters(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/v1/deadletters"), "/")
+	if len(key) == 0 {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ := json.Marshal(h.p.DeadLetters())
+		w.Write(b)
+		return
+	}
+
+	var ok bool
+	switch r.Method {
+	case "POST":
+		ok = h.p.RetryDeadLetter(key)
+	case "DELETE":
+		ok = h.p.DropDeadLetter(key)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,196 @@
+// package bench generates workloads against a cache and reports its
+// performance, so changes across versions are compared by the same numbers.
+package bench
+
+import (
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/huangml/proxycache"
+	"github.com/huangml/proxycache/cache"
+)
+
+// Target is what a workload runs against.
+type Target interface {
+	// Get reads a key, and returns whether it is found.
+	Get(key string) (found bool)
+	Put(key string, value []byte) error
+}
+
+// Workload describes the operations to run.
+type Workload struct {
+	Keys        int     // number of distinct keys
+	Zipf        float64 // skew of key distribution, greater than 1, or 0 for uniform
+	ReadRatio   float64 // fraction of operations being reads
+	ValueSize   int     // bytes of values written
+	Ops         int     // total operations
+	Concurrency int     // goroutines running operations, at least 1
+	Seed        int64
+}
+
+// Report is the result of running a workload.
+type Report struct {
+	Ops    int `json:"ops"`
+	Reads  int `json:"reads"`
+	Writes int `json:"writes"`
+	Hits   int `json:"hits"`
+	Errors int `json:"errors"`
+
+	Elapsed    time.Duration `json:"elapsed"`
+	Throughput float64       `json:"throughput"` // operations per second
+
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+
+	AllocsPerOp float64 `json:"allocsPerOp"`
+	BytesPerOp  float64 `json:"bytesPerOp"`
+}
+
+// HitRatio returns the fraction of reads found.
+func (r Report) HitRatio() float64 {
+	if r.Reads == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Reads)
+}
+
+type worker struct {
+	reads, writes, hits, errors int
+	latencies                   []time.Duration
+}
+
+// Run runs a workload against a target, and reports its performance.
+func Run(t Target, w Workload) Report {
+	if w.Concurrency < 1 {
+		w.Concurrency = 1
+	}
+	if w.Keys < 1 {
+		w.Keys = 1
+	}
+
+	workers := make([]*worker, w.Concurrency)
+	var wg sync.WaitGroup
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	for i := range workers {
+		ops := w.Ops / w.Concurrency
+		if i < w.Ops%w.Concurrency {
+			ops++
+		}
+		wk := &worker{latencies: make([]time.Duration, 0, ops)}
+		workers[i] = wk
+
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			wk.run(t, w, ops, seed)
+		}(w.Seed + int64(i))
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	r := Report{Ops: w.Ops, Elapsed: elapsed}
+	var latencies []time.Duration
+	for _, wk := range workers {
+		r.Reads += wk.reads
+		r.Writes += wk.writes
+		r.Hits += wk.hits
+		r.Errors += wk.errors
+		latencies = append(latencies, wk.latencies...)
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(r.Ops) / elapsed.Seconds()
+	}
+	if r.Ops > 0 {
+		r.AllocsPerOp = float64(after.Mallocs-before.Mallocs) / float64(r.Ops)
+		r.BytesPerOp = float64(after.TotalAlloc-before.TotalAlloc) / float64(r.Ops)
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	if n := len(latencies); n > 0 {
+		r.P50 = latencies[n*50/100]
+		r.P90 = latencies[n*90/100]
+		r.P99 = latencies[n*99/100]
+		r.Max = latencies[n-1]
+	}
+	return r
+}
+
+func (wk *worker) run(t Target, w Workload, ops int, seed int64) {
+	rnd := rand.New(rand.NewSource(seed))
+	next := func() int { return rnd.Intn(w.Keys) }
+	if w.Zipf > 1 {
+		z := rand.NewZipf(rnd, w.Zipf, 1, uint64(w.Keys-1))
+		next = func() int { return int(z.Uint64()) }
+	}
+	value := make([]byte, w.ValueSize)
+	rnd.Read(value)
+
+	for i := 0; i < ops; i++ {
+		key := "key" + strconv.Itoa(next())
+		read := rnd.Float64() < w.ReadRatio
+
+		start := time.Now()
+		if read {
+			if t.Get(key) {
+				wk.hits++
+			}
+			wk.reads++
+		} else {
+			if err := t.Put(key, value); err != nil {
+				wk.errors++
+			}
+			wk.writes++
+		}
+		wk.latencies = append(wk.latencies, time.Since(start))
+	}
+}
+
+type cacheTarget struct {
+	c *cache.Cache
+}
+
+// CacheTarget runs workloads against a Cache only.
+func CacheTarget(c *cache.Cache) Target {
+	return cacheTarget{c}
+}
+
+func (t cacheTarget) Get(key string) bool {
+	return t.c.Get(key) != nil
+}
+
+func (t cacheTarget) Put(key string, value []byte) error {
+	t.c.Put(&cache.Entry{Key: key, Value: value})
+	return nil
+}
+
+type proxyTarget struct {
+	p   *proxycache.ProxyCache
+	ttw int64
+}
+
+// ProxyTarget runs workloads against a ProxyCache, so loading and saving are
+// included. Writes are put with ttw.
+func ProxyTarget(p *proxycache.ProxyCache, ttw int64) Target {
+	return proxyTarget{p, ttw}
+}
+
+func (t proxyTarget) Get(key string) bool {
+	return t.p.Get(key) != nil
+}
+
+func (t proxyTarget) Put(key string, value []byte) error {
+	return t.p.Put(key, value, t.ttw)
+}
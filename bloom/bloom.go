@@ -0,0 +1,95 @@
+// package bloom implements a bloom filter.
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Filter is a bloom filter of string keys.
+// Test never returns false for keys added, but may return true for keys not
+// added, with a probability set by New.
+type Filter struct {
+	bits []uint64
+	m    uint64 // number of bits
+	k    int    // number of hashes
+	n    int    // number of keys added
+	c    int    // capacity
+}
+
+// New creates a Filter, which holds up to n keys with a false positive rate
+// of fp.
+func New(n int, fp float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if fp <= 0 || fp >= 1 {
+		fp = 0.01
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(fp) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &Filter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+		c:    n,
+	}
+}
+
+// hashes returns two hashes, the others are derived by double hashing.
+func hashes(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h1 := h.Sum64()
+	h2 := h1>>33 | h1<<31
+	return h1, h2 | 1
+}
+
+// Add adds a key.
+func (f *Filter) Add(key string) {
+	h1, h2 := hashes(key)
+	for i := 0; i < f.k; i++ {
+		b := (h1 + uint64(i)*h2) % f.m
+		f.bits[b/64] |= 1 << (b % 64)
+	}
+	f.n++
+}
+
+// Test tells whether a key may have been added.
+func (f *Filter) Test(key string) bool {
+	h1, h2 := hashes(key)
+	for i := 0; i < f.k; i++ {
+		b := (h1 + uint64(i)*h2) % f.m
+		if f.bits[b/64]&(1<<(b%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns the number of keys added.
+func (f *Filter) Len() int {
+	return f.n
+}
+
+// Cap returns the number of keys the filter is created for. The false positive
+// rate grows if more keys are added.
+func (f *Filter) Cap() int {
+	return f.c
+}
+
+// Reset removes all keys.
+func (f *Filter) Reset() {
+	for i := range f.bits {
+		f.bits[i] = 0
+	}
+	f.n = 0
+}
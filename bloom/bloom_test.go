@@ -0,0 +1,55 @@
+package bloom
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		n  int
+		fp float64
+	}{
+		{1000, 0.01},
+		{10000, 0.01},
+		{10000, 0.001},
+		{100, 0.1},
+	}
+
+	for _, tt := range tests {
+		f := New(tt.n, tt.fp)
+		for i := 0; i < tt.n; i++ {
+			f.Add("added" + strconv.Itoa(i))
+		}
+		for i := 0; i < tt.n; i++ {
+			if !f.Test("added" + strconv.Itoa(i)) {
+				t.Fatalf("New(%d, %v): Test() = false of a key added", tt.n, tt.fp)
+			}
+		}
+
+		const tests = 100000
+		positive := 0
+		for i := 0; i < tests; i++ {
+			if f.Test("other" + strconv.Itoa(i)) {
+				positive++
+			}
+		}
+		if got := float64(positive) / tests; got > 2*tt.fp {
+			t.Errorf("New(%d, %v): false positive rate %.4f", tt.n, tt.fp, got)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	f := New(10, 0.01)
+	f.Add("a")
+	f.Add("b")
+	if f.Len() != 2 || f.Cap() != 10 {
+		t.Fatalf("Len(), Cap() = %d, %d, want 2, 10", f.Len(), f.Cap())
+	}
+
+	f.Reset()
+	if f.Len() != 0 || f.Test("a") || f.Test("b") {
+		t.Errorf("Len() = %d, Test(a) = %v, Test(b) = %v after Reset", f.Len(), f.Test("a"), f.Test("b"))
+	}
+}
@@ -0,0 +1,65 @@
+// package bus defines the Bus interface which broadcasts invalidations between
+// ProxyCache instances.
+package bus
+
+import "sync"
+
+// Message tells that a key is changed by the instance Origin.
+type Message struct {
+	Origin string `json:"origin"`
+	Key    string `json:"key"`
+}
+
+// Bus is the interface broadcasts messages to all subscribers.
+type Bus interface {
+	Publish(m Message) error
+
+	// Subscribe calls f on every message published, until cancel is called.
+	Subscribe(f func(m Message)) (cancel func(), err error)
+}
+
+// Local is an in-process Bus.
+type Local struct {
+	mtx  sync.Mutex
+	seq  int
+	subs map[int]func(m Message)
+}
+
+// NewLocal creates a Local bus.
+func NewLocal() *Local {
+	return &Local{
+		subs: make(map[int]func(m Message)),
+	}
+}
+
+// Publish calls all subscribers synchronously.
+func (l *Local) Publish(m Message) error {
+	l.mtx.Lock()
+	subs := make([]func(m Message), 0, len(l.subs))
+	for _, f := range l.subs {
+		subs = append(subs, f)
+	}
+	l.mtx.Unlock()
+
+	for _, f := range subs {
+		f(m)
+	}
+	return nil
+}
+
+// Subscribe subscribes messages published after it.
+func (l *Local) Subscribe(f func(m Message)) (func(), error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.seq++
+	id := l.seq
+	l.subs[id] = f
+
+	return func() {
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+
+		delete(l.subs, id)
+	}, nil
+}
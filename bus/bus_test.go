@@ -0,0 +1,26 @@
+package bus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	b := NewLocal()
+	var a, c []string
+	cancelA, _ := b.Subscribe(func(m Message) { a = append(a, m.Key) })
+	b.Publish(Message{Origin: "o", Key: "1"})
+	cancelC, _ := b.Subscribe(func(m Message) { c = append(c, m.Key) })
+	b.Publish(Message{Origin: "o", Key: "2"})
+	cancelA()
+	b.Publish(Message{Origin: "o", Key: "3"})
+	cancelC()
+	b.Publish(Message{Origin: "o", Key: "4"})
+
+	if want := []string{"1", "2"}; !reflect.DeepEqual(a, want) {
+		t.Errorf("first subscriber got %q, want %q", a, want)
+	}
+	if want := []string{"2", "3"}; !reflect.DeepEqual(c, want) {
+		t.Errorf("second subscriber got %q, want %q", c, want)
+	}
+}
@@ -0,0 +1,181 @@
+package bus
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/huangml/proxycache/clock"
+	"github.com/huangml/proxycache/resp"
+)
+
+// Redis is a Bus based on Redis pub/sub.
+type Redis struct {
+	addr    string
+	channel string
+
+	mtx   sync.Mutex
+	conn  net.Conn
+	r     *bufio.Reader
+	w     *bufio.Writer
+	clock clock.Clock
+}
+
+// NewRedis creates a Redis bus, messages are published to channel of the Redis
+// server at addr.
+func NewRedis(addr, channel string) *Redis {
+	return &Redis{
+		addr:    addr,
+		channel: channel,
+		clock:   clock.Real,
+	}
+}
+
+// SetClock sets the clock of redialing and deadlines of commands, so they can
+// be tested by a fake clock. It should be called before Subscribe.
+func (r *Redis) SetClock(clk clock.Clock) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.clock = clk
+}
+
+// Publish publishes a message by the PUBLISH command.
+// The connection is dialed on first use, and redialed after failures.
+func (r *Redis) Publish(m Message) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.conn == nil {
+		conn, err := net.DialTimeout("tcp", r.addr, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		r.conn, r.r, r.w = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+	}
+
+	err = r.do("PUBLISH", r.channel, string(b))
+	if err != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+	return err
+}
+
+func (r *Redis) do(args ...string) error {
+	r.conn.SetDeadline(r.clock.Now().Add(5 * time.Second))
+	resp.Write(r.w, args)
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+
+	v, err := resp.Read(r.r)
+	if err != nil {
+		return err
+	}
+	if e, ok := v.(resp.Error); ok {
+		return e
+	}
+	return nil
+}
+
+// Subscribe subscribes messages on a dedicated connection by the SUBSCRIBE
+// command. Broken connection is redialed every second, messages published
+// meanwhile are lost.
+func (r *Redis) Subscribe(f func(m Message)) (func(), error) {
+	conn, err := r.subscribe()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mtx.Lock()
+	clk := r.clock
+	r.mtx.Unlock()
+
+	var mtx sync.Mutex
+	stop := make(chan struct{})
+
+	go func() {
+		for {
+			r.receive(conn, f)
+
+			for {
+				select {
+				case <-stop:
+					return
+				case <-clk.After(time.Second):
+				}
+
+				mtx.Lock()
+				select {
+				case <-stop:
+					mtx.Unlock()
+					return
+				default:
+				}
+				conn, err = r.subscribe()
+				mtx.Unlock()
+				if err == nil {
+					break
+				}
+			}
+		}
+	}()
+
+	return func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		close(stop)
+		if conn != nil {
+			conn.Close()
+		}
+	}, nil
+}
+
+func (r *Redis) subscribe() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", r.addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(conn)
+	resp.Write(w, []string{"SUBSCRIBE", r.channel})
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// receive handles messages until the connection is broken.
+func (r *Redis) receive(conn net.Conn, f func(m Message)) {
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	for {
+		v, err := resp.Read(br)
+		if err != nil {
+			return
+		}
+
+		// [message, channel, payload]
+		a, ok := v.([]interface{})
+		if !ok || len(a) != 3 || a[0] != "message" {
+			continue
+		}
+		payload, _ := a[2].(string)
+
+		var m Message
+		if json.Unmarshal([]byte(payload), &m) == nil {
+			f(m)
+		}
+	}
+}
@@ -1,19 +1,56 @@
 package cache
 
 import (
+	"errors"
 	"sync"
 	"time"
 
+	"github.com/huangml/proxycache/clock"
+	"github.com/huangml/proxycache/lru"
 	"github.com/huangml/proxycache/priority-queue/pq"
 )
 
+// maxSavedVersions is how many keys saved recently Buffer remembers the
+// versions of, see Put.
+const maxSavedVersions = 4096
+
+// ErrBufferFull is returned by Admit if Buffer is over its high-water mark.
+var ErrBufferFull = errors.New("cache: buffer is full")
+
 // Buffer is a container which holds entries need to be save.
 type Buffer struct {
 	entries map[string]*Entry
 	q       *pq.PriorityQueue
+	since   *pq.PriorityQueue // keys in q, by the time they are queued
+
+	coalesced uint64 // writes replaced by newer ones before pumped
+
+	saved    map[string]uint64 // versions of keys saved recently
+	savedUse *lru.LRU
+
+	maxRetry int
+	failures map[string]int
+	dead     map[string]*DeadLetter
 
-	out  chan *Entry
-	cond *sync.Cond
+	highWater int
+	block     bool
+	reserved  int        // room reserved by Admit, for entries not put yet
+	space     *sync.Cond // signaled when entries are removed
+
+	// write-behind mode, see SetFlushInterval.
+	interval  time.Duration
+	threshold int
+	flushing  bool
+	paused    bool
+	lastFlush time.Time
+	stopFlush chan struct{}
+
+	closed bool
+	done   chan struct{} // closed by Close
+
+	clock clock.Clock
+	out   chan *Entry
+	cond  *sync.Cond
 }
 
 // NewBuffer creates a Buffer.
@@ -21,27 +58,53 @@ type Buffer struct {
 func NewBuffer() *Buffer {
 	var l sync.Mutex
 	b := &Buffer{
-		entries: make(map[string]*Entry),
-		q:       pq.New(),
-		out:     make(chan *Entry),
-		cond:    sync.NewCond(&l),
+		entries:  make(map[string]*Entry),
+		q:        pq.New(),
+		since:    pq.New(),
+		failures: make(map[string]int),
+		saved:    make(map[string]uint64),
+		savedUse: lru.New(),
+		dead:     make(map[string]*DeadLetter),
+		flushing: true,
+		clock:    clock.Real,
+		done:     make(chan struct{}),
+		out:      make(chan *Entry),
+		cond:     sync.NewCond(&l),
+		space:    sync.NewCond(&l),
 	}
 
 	go func() {
-		pump := func() *Entry {
+		pump := func() (*Entry, bool) {
 			b.cond.L.Lock()
 			defer b.cond.L.Unlock()
 
-			for b.q.Len() == 0 {
+			for (b.q.Len() == 0 || !b.flushing || b.paused) && !b.closed {
 				b.cond.Wait()
 			}
+			if b.closed {
+				return nil, false
+			}
 
-			return b.entries[b.q.Pop().(string)]
+			key := b.q.Pop().(string)
+			b.since.Remove(key)
+			if b.q.Len() == 0 && b.interval > 0 {
+				b.flushing = false
+				b.lastFlush = b.clock.Now()
+			}
+			return b.entries[key], true
 		}
 
 		for {
-			if entry := pump(); entry != nil {
-				b.out <- entry
+			entry, ok := pump()
+			if !ok {
+				return
+			}
+			if entry != nil {
+				select {
+				case b.out <- entry:
+				case <-b.done:
+					return
+				}
 			}
 		}
 	}()
@@ -55,58 +118,384 @@ func (b *Buffer) Entries() <-chan *Entry {
 	return b.out
 }
 
-// Get looks up an entry by the provided key.
+// Get looks up an entry by the provided key, including dead letters.
+// The entry may be a deletion, see Entry.Deleted.
 func (b *Buffer) Get(key string) *Entry {
 	b.cond.L.Lock()
 	defer b.cond.L.Unlock()
 
-	e, _ := b.entries[key]
-	return e
+	return b.getWithLock(key)
+}
+
+func (b *Buffer) getWithLock(key string) *Entry {
+	if e, ok := b.entries[key]; ok {
+		return e
+	}
+	if d, ok := b.dead[key]; ok {
+		return d.Entry
+	}
+	return nil
 }
 
 // Put puts an entry to Buffer.
 // The entry will be pumped into out channel, orderd by priority.
 // Entry's priority is `current unix epoch time + ttw`.
+// If the key is already waiting in queue, the entries are coalesced, only the
+// newest one will be pumped.
+// Entry older than the one in Buffer, or the one of the key saved recently,
+// by comparing their versions, is ignored, so entries are saved in the order
+// they were put into Cache.
 func (b *Buffer) Put(entry *Entry, ttw int64) {
 	b.cond.L.Lock()
 	defer b.cond.L.Unlock()
 
+	if e := b.getWithLock(entry.Key); e != nil && entry.Version < e.Version {
+		return
+	}
+	if v, ok := b.saved[entry.Key]; ok && entry.Version < v {
+		return
+	}
+
 	b.entries[entry.Key] = entry
-	priority := time.Now().Unix() + ttw
+	delete(b.dead, entry.Key)
+	priority := b.clock.Now().Unix() + ttw
 
 	// if the key is already in queue, use the higher priority.
-	if oldPriority, ok := b.q.Priority(entry.Key); ok && oldPriority <= priority {
+	oldPriority, queued := b.q.Priority(entry.Key)
+	if queued {
+		b.coalesced++
+	}
+	if queued && oldPriority <= priority {
 		return
 	}
 
-	b.q.Push(entry.Key, priority)
+	b.pushWithLock(entry.Key, priority)
+	if b.threshold > 0 && b.q.Len() >= b.threshold {
+		b.flushing = true
+	}
 	b.cond.Signal()
 }
 
+func (b *Buffer) pushWithLock(key string, priority int64) {
+	if _, ok := b.q.Priority(key); !ok {
+		b.since.Push(key, b.clock.Now().UnixNano())
+	}
+	b.q.Push(key, priority)
+}
+
+// SetClock sets the clock of priorities and flushing, so they can be tested by
+// a fake clock. It should be called before SetFlushInterval.
+func (b *Buffer) SetClock(clk clock.Clock) {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	b.clock = clk
+}
+
+// SetFlushInterval enables write-behind mode, in which entries are held in
+// Buffer, and flushed all together every interval, or when the number of
+// entries waiting is more than threshold. If threshold is 0, there is no limit.
+// If interval is 0, write-behind mode is disabled, entries are pumped as soon
+// as possible.
+func (b *Buffer) SetFlushInterval(interval time.Duration, threshold int) {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	if b.stopFlush != nil {
+		close(b.stopFlush)
+		b.stopFlush = nil
+	}
+
+	b.interval = interval
+	b.threshold = threshold
+	b.flushing = interval <= 0
+	if b.interval > 0 && !b.closed {
+		stop := make(chan struct{})
+		b.stopFlush = stop
+		t := b.clock.NewTimer(interval)
+		go func() {
+			defer t.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-t.C():
+					b.Flush()
+					t.Reset(interval)
+				}
+			}
+		}()
+	}
+	b.cond.Signal()
+}
+
+// Close stops pumping entries, and flushing in write-behind mode. Entries put
+// afterwards are kept in Buffer, but never pumped.
+func (b *Buffer) Close() {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.done)
+	if b.stopFlush != nil {
+		close(b.stopFlush)
+		b.stopFlush = nil
+	}
+	b.cond.Signal()
+}
+
+// Flush starts pumping all entries waiting in Buffer immediately.
+func (b *Buffer) Flush() {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	if b.q.Len() > 0 {
+		b.flushing = true
+		b.cond.Signal()
+	} else {
+		b.lastFlush = b.clock.Now()
+	}
+}
+
+// SetPaused pauses or resumes pumping entries. Paused entries are still put
+// and coalesced, and pumped after resuming.
+func (b *Buffer) SetPaused(paused bool) {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	b.paused = paused
+	b.cond.Signal()
+}
+
+// SetHighWater sets the high-water mark of Buffer, see Admit.
+// If highWater is 0, Buffer grows unboundedly.
+func (b *Buffer) SetHighWater(highWater int, block bool) {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	b.highWater = highWater
+	b.block = block
+	b.space.Broadcast()
+}
+
+// Admit reserves room for entries of keys to be put into Buffer, it returns
+// the function releasing the room, which should be called once they are put.
+// If pending entries and room reserved would exceed the high-water mark, it
+// blocks until some entries are saved, or returns ErrBufferFull if not
+// blocking. Keys already pending take no room, since they don't grow Buffer,
+// and keys more than the high-water mark are admitted once Buffer is empty.
+func (b *Buffer) Admit(keys ...string) (release func(), err error) {
+	return b.admit(keys, true)
+}
+
+// TryAdmit reserves room like Admit, but never blocks.
+func (b *Buffer) TryAdmit(keys ...string) (release func(), err error) {
+	return b.admit(keys, false)
+}
+
+func (b *Buffer) admit(keys []string, wait bool) (func(), error) {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	n := 0
+	for b.highWater > 0 {
+		n = b.newKeysWithLock(keys)
+		used := len(b.entries) + b.reserved
+		if n == 0 || used == 0 || used+n <= b.highWater {
+			break
+		}
+		if !wait || !b.block {
+			return nil, ErrBufferFull
+		}
+		b.space.Wait()
+	}
+	if n == 0 {
+		return func() {}, nil
+	}
+
+	b.reserved += n
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.cond.L.Lock()
+			defer b.cond.L.Unlock()
+
+			b.reserved -= n
+			b.space.Broadcast()
+		})
+	}, nil
+}
+
+// newKeysWithLock returns the number of distinct keys not pending.
+func (b *Buffer) newKeysWithLock(keys []string) int {
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := b.entries[key]; !ok {
+			seen[key] = struct{}{}
+		}
+	}
+	return len(seen)
+}
+
+// Pending returns the number of entries not saved yet, excluding dead letters.
+func (b *Buffer) Pending() int {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	return len(b.entries)
+}
+
 // OnSave handles entry saving result.
 // If succesed, the entry will removed from Buffer.
 // If failed, the entry will be pushed back to Buffer and saved later again.
+// If the key fails more than maxRetry times, the entry is moved to dead letters.
 func (b *Buffer) OnSave(entry *Entry, ok bool) {
 	b.cond.L.Lock()
 	defer b.cond.L.Unlock()
 
 	if ok {
+		delete(b.failures, entry.Key)
+		b.rememberSavedWithLock(entry)
+		// a newer entry put while saving may be pumped, and not pushed back
+		// by OnBusy yet.
+		if e, ok := b.entries[entry.Key]; ok && e.Version > entry.Version {
+			return
+		}
 		if _, needSave := b.q.Priority(entry.Key); !needSave {
 			delete(b.entries, entry.Key)
+			b.space.Broadcast()
 		}
-	} else {
-		if _, ok := b.entries[entry.Key]; !ok {
-			b.entries[entry.Key] = entry
+		return
+	}
+
+	b.failures[entry.Key]++
+	_, queued := b.q.Priority(entry.Key)
+	if b.maxRetry > 0 && b.failures[entry.Key] > b.maxRetry && !queued {
+		if e, ok := b.entries[entry.Key]; ok {
+			entry = e
 		}
-		priority := time.Now().Unix() + 1
-		b.q.Push(entry.Key, priority)
-		b.cond.Signal()
+		b.dead[entry.Key] = &DeadLetter{
+			Entry:       entry,
+			Failures:    b.failures[entry.Key],
+			LastFailure: b.clock.Now().Unix(),
+		}
+		delete(b.failures, entry.Key)
+		delete(b.entries, entry.Key)
+		b.space.Broadcast()
+		return
+	}
+	b.requeueWithLock(entry)
+}
+
+// Saved returns the version of the key saved last, if the key is saved
+// recently, see Put.
+func (b *Buffer) Saved(key string) (version uint64, ok bool) {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	version, ok = b.saved[key]
+	return
+}
+
+// rememberSavedWithLock remembers the version of an entry saved, so an older
+// one put late is not saved over it. Only the most recent keys are remembered,
+// since entries are put into Buffer right after Cache.
+func (b *Buffer) rememberSavedWithLock(entry *Entry) {
+	if v, ok := b.saved[entry.Key]; ok && v > entry.Version {
+		return
+	}
+	b.saved[entry.Key] = entry.Version
+	b.savedUse.Touch(entry.Key)
+	for b.savedUse.Len() > maxSavedVersions {
+		delete(b.saved, b.savedUse.Pop().(string))
 	}
 }
 
+// OnBusy handles entry which can't be saved now, because the key is in saving.
+// The entry will be pushed back to Buffer, without counting as a failure.
+func (b *Buffer) OnBusy(entry *Entry) {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	b.requeueWithLock(entry)
+}
+
+func (b *Buffer) requeueWithLock(entry *Entry) {
+	if _, ok := b.entries[entry.Key]; !ok {
+		b.entries[entry.Key] = entry
+	}
+	priority := b.clock.Now().Unix() + 1
+	b.pushWithLock(entry.Key, priority)
+	b.cond.Signal()
+}
+
+// DeadLetter is an entry failed to save too many times.
+type DeadLetter struct {
+	Entry       *Entry `json:"entry"`
+	Failures    int    `json:"failures"`
+	LastFailure int64  `json:"lastFailure"` // unix epoch time
+}
+
+// SetMaxRetry sets how many times a failed entry is retried before moved to
+// dead letters. If maxRetry is 0, failed entries are retried forever.
+func (b *Buffer) SetMaxRetry(maxRetry int) {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	b.maxRetry = maxRetry
+}
+
+// DeadLetters returns all dead letters.
+// Dead letters are kept until retried, dropped, or replaced by a new Put.
+func (b *Buffer) DeadLetters() []DeadLetter {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	letters := make([]DeadLetter, 0, len(b.dead))
+	for _, d := range b.dead {
+		letters = append(letters, *d)
+	}
+	return letters
+}
+
+// RetryDeadLetter pushes a dead letter back to Buffer to be saved again.
+// It returns false if the key is not a dead letter.
+func (b *Buffer) RetryDeadLetter(key string) bool {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	d, ok := b.dead[key]
+	if !ok {
+		return false
+	}
+	delete(b.dead, key)
+	b.requeueWithLock(d.Entry)
+	return true
+}
+
+// DropDeadLetter removes a dead letter, without saving it.
+// It returns false if the key is not a dead letter.
+func (b *Buffer) DropDeadLetter(key string) bool {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+
+	_, ok := b.dead[key]
+	delete(b.dead, key)
+	return ok
+}
+
 // BufferStatus is used for runtime performance profiling.
 type BufferStatus struct {
-	BufferSize int `json:"bufferSize"`
+	BufferSize int   `json:"bufferSize"`
+	DirtyCount int   `json:"dirtyCount"` // entries waiting to be pumped
+	FlushLag   int64 `json:"flushLag"`   // milliseconds the oldest dirty entry waited
+	LastFlush  int64 `json:"lastFlush"`  // unix epoch time, only in write-behind mode
+
+	CoalescedWrites uint64 `json:"coalescedWrites"`
+	DeadLetters     int    `json:"deadLetters"`
 }
 
 // Status returns Buffer's runtime performance status.
@@ -114,7 +503,18 @@ func (b *Buffer) Status() BufferStatus {
 	b.cond.L.Lock()
 	defer b.cond.L.Unlock()
 
-	return BufferStatus{
+	s := BufferStatus{
 		BufferSize: len(b.entries),
+		DirtyCount: b.q.Len(),
+
+		CoalescedWrites: b.coalesced,
+		DeadLetters:     len(b.dead),
+	}
+	if _, since := b.since.Peek(); since > 0 {
+		s.FlushLag = (b.clock.Now().UnixNano() - since) / int64(time.Millisecond)
+	}
+	if !b.lastFlush.IsZero() {
+		s.LastFlush = b.lastFlush.Unix()
 	}
+	return s
 }
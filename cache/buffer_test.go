@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBufferOrdering(t *testing.T) {
+	tests := []struct {
+		name string
+		// ops are "put <version>", "save" or "fail" of the entry pumped, and
+		// "take" of an entry pumped, which is "saved" later in order.
+		ops  string
+		want uint64 // version pending, 0 if none
+	}{
+		{"newer replaces", "put 1, put 2", 2},
+		{"older ignored", "put 2, put 1", 2},
+		{"saved", "put 2, save", 0},
+		{"older than saved ignored", "put 2, save, put 1", 0},
+		{"newer than saved", "put 2, save, put 3", 3},
+		{"failed kept", "put 2, fail", 2},
+		{"older than failed ignored", "put 2, fail, put 1", 2},
+		{"newer than failed", "put 1, fail, put 2", 2},
+		{"newer kept while saving", "put 1, take, put 2, saved", 2},
+		{"newer saved after saving", "put 1, take, put 2, saved, save", 0},
+		{"newer kept while pumped", "put 1, take, put 2, take, saved", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBuffer()
+			pumped := func() *Entry {
+				select {
+				case e := <-b.Entries():
+					return e
+				case <-time.After(5 * time.Second):
+					t.Fatalf("%s: no entry pumped", tt.ops)
+					return nil
+				}
+			}
+
+			var taken []*Entry
+			for _, op := range strings.Split(tt.ops, ", ") {
+				switch {
+				case strings.HasPrefix(op, "put "):
+					v, _ := strconv.ParseUint(op[4:], 10, 64)
+					b.Put(&Entry{Key: "k", Version: v}, 0)
+				case op == "save" || op == "fail":
+					b.OnSave(pumped(), op == "save")
+				case op == "take":
+					taken = append(taken, pumped())
+				case op == "saved":
+					b.OnSave(taken[0], true)
+					taken = taken[1:]
+				}
+			}
+
+			var got uint64
+			if e := b.Get("k"); e != nil {
+				got = e.Version
+			}
+			if got != tt.want {
+				t.Errorf("%s: version %d pending, want %d", tt.ops, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBufferCoalesced(t *testing.T) {
+	b := NewBuffer()
+	b.SetPaused(true)
+
+	b.Put(&Entry{Key: "a", Version: 1}, 0)
+	b.Put(&Entry{Key: "b", Version: 2}, 0)
+	if n := b.Status().CoalescedWrites; n != 0 {
+		t.Fatalf("%d writes coalesced of different keys", n)
+	}
+	b.Put(&Entry{Key: "a", Version: 3}, 0)
+	if n := b.Status().CoalescedWrites; n != 1 {
+		t.Fatalf("%d writes coalesced after a queued key is put again, want 1", n)
+	}
+
+	b.SetPaused(false)
+	b.OnSave(<-b.Entries(), true)
+	b.OnSave(<-b.Entries(), true)
+	b.Put(&Entry{Key: "a", Version: 4}, 0)
+	if n := b.Status().CoalescedWrites; n != 1 {
+		t.Errorf("%d writes coalesced after a saved key is put again, want 1", n)
+	}
+}
+
+func TestBufferAdmit(t *testing.T) {
+	b := NewBuffer()
+	b.SetPaused(true)
+	b.SetHighWater(3, false)
+
+	// keys over the high-water mark are admitted into an empty Buffer.
+	release, err := b.TryAdmit("a", "b", "c", "d")
+	if err != nil {
+		t.Fatalf("TryAdmit() into empty Buffer = %v", err)
+	}
+	release()
+
+	release, err = b.TryAdmit("a", "b", "b")
+	if err != nil {
+		t.Fatalf("TryAdmit(a, b, b) = %v", err)
+	}
+	if _, err := b.TryAdmit("c", "d"); err != ErrBufferFull {
+		t.Fatalf("TryAdmit(c, d) over reserved room = %v, want %v", err, ErrBufferFull)
+	}
+	b.Put(&Entry{Key: "a", Version: 1}, 0)
+	b.Put(&Entry{Key: "b", Version: 1}, 0)
+	release()
+	release() // releasing twice is harmless
+
+	if _, err := b.TryAdmit("c", "d"); err != ErrBufferFull {
+		t.Fatalf("TryAdmit(c, d) over pending entries = %v, want %v", err, ErrBufferFull)
+	}
+	release, err = b.TryAdmit("a", "c")
+	if err != nil {
+		t.Fatalf("TryAdmit(a, c) with a pending = %v", err)
+	}
+	release()
+
+	// Admit is not blocking either unless set.
+	if _, err := b.Admit("c", "d"); err != ErrBufferFull {
+		t.Fatalf("Admit(c, d) = %v, want %v", err, ErrBufferFull)
+	}
+}
+
+func TestBufferAdmitBlocking(t *testing.T) {
+	b := NewBuffer()
+	b.SetHighWater(1, true)
+
+	b.Put(&Entry{Key: "a", Version: 1}, 0)
+	admitted := make(chan error)
+	go func() {
+		release, err := b.Admit("b")
+		if err == nil {
+			release()
+		}
+		admitted <- err
+	}()
+
+	select {
+	case err := <-admitted:
+		t.Fatalf("Admit() = %v before room is made", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if _, err := b.TryAdmit("b"); err != ErrBufferFull {
+		t.Fatalf("TryAdmit() = %v, want %v", err, ErrBufferFull)
+	}
+
+	b.OnSave(<-b.Entries(), true)
+	if err := <-admitted; err != nil {
+		t.Fatalf("Admit() = %v after room is made", err)
+	}
+}
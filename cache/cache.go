@@ -3,7 +3,9 @@ package cache
 
 import (
 	"sync"
+	"time"
 
+	"github.com/huangml/proxycache/clock"
 	"github.com/huangml/proxycache/lru"
 )
 
@@ -11,18 +13,68 @@ import (
 // It auto removes least-recently-used entry when cache is full.
 type Cache struct {
 	maxEntry int
-	entries  map[string]*Entry
+	ttl      time.Duration
+	softTTL  time.Duration
+	maxStale time.Duration
+	entries  map[string]*item
+	bytes    int64 // sum of entries' Size()
+	version  uint64
+	leases   map[string]*lease
+	granted  []*lease // leases by the time granted, see pruneLeasesWithLock
+	door     *doorkeeper
+	cleaner  *cleaner
 	use      *lru.LRU
+	clock    clock.Clock
 	mtx      sync.Mutex
+
+	expiration Expiration
+	indexKind  ExpiryIndex
+	indexTick  time.Duration
+	index      expiryIndex // nil if NoIndex
+
+	dedupMin int
+	values   map[uint64]*sharedValue // nil if dedup is disabled
+
+	namespace func(key string) string
+	quotas    map[string]*quota
+
+	stopPressure chan struct{}
+	shrunk       uint64 // entries evicted by Shrink
+	hotWindow    time.Duration
+	refreshed    uint64 // entries told to refresh
+
+	onEvict func(entry *Entry)
+	evicted []*Entry // evicted with lock held, waiting for onEvict
+}
+
+// item holds an entry with its cache-only properties.
+type item struct {
+	entry    *Entry
+	expire   time.Time // zero means never expire
+	refresh  time.Time // zero means never refresh, see SetSoftTTL
+	created  time.Time
+	accessed time.Time
+	hits     uint64 // of the key, kept across puts
+	recent   recentHits
+	shared   *sharedValue
+}
+
+func (i *item) expired(now time.Time) bool {
+	return !i.expire.IsZero() && !now.Before(i.expire)
 }
 
 // NewCache creates a new Cache.
 // If maxEntry is 0, the cache has no limit size.
 func NewCache(maxEntry int) *Cache {
 	return &Cache{
-		maxEntry: maxEntry,
-		entries:  make(map[string]*Entry),
-		use:      lru.New(),
+		maxEntry:   maxEntry,
+		entries:    make(map[string]*item),
+		leases:     make(map[string]*lease),
+		quotas:     make(map[string]*quota),
+		use:        lru.New(),
+		clock:      clock.Real,
+		expiration: LazyExpiration,
+		hotWindow:  DefaultHotWindow,
 	}
 }
 
@@ -30,35 +82,303 @@ func NewCache(maxEntry int) *Cache {
 // Extra entries will be removed immediately.
 func (c *Cache) SetMaxEntry(maxEntry int) {
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
+	defer c.unlock()
 
 	c.maxEntry = maxEntry
 	c.checkMaxEntryWithLock()
 }
 
+// SetOnEvict sets a handler which is called when an entry is evicted,
+// expired or cleared.
+func (c *Cache) SetOnEvict(f func(entry *Entry)) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.onEvict = f
+}
+
+// SetClock sets the clock of TTL, leases, expiration and other time-based
+// behaviors, so they can be tested by a fake clock. It should be called before
+// setting up timers, like SetExpiration and SetDoorkeeper.
+func (c *Cache) SetClock(clk clock.Clock) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.clock = clk
+	c.rebuildIndexWithLock()
+}
+
+// SetTTL sets the time-to-live of entries put afterwards.
+// If ttl is 0, entries never expire.
+func (c *Cache) SetTTL(ttl time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.ttl = ttl
+}
+
+// SetMaxStale sets how long expired entries are kept for serving stale, see
+// GetStale. If maxStale is 0, expired entries are removed once found.
+func (c *Cache) SetMaxStale(maxStale time.Duration) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	c.maxStale = maxStale
+	c.rebuildIndexWithLock()
+}
+
 // Get looks up entry by a key.
-// It marks the key as recently-used.
+// It marks the key as recently-used. Nil is returned for expired entry, which
+// is removed unless kept for serving stale.
 func (c *Cache) Get(key string) *Entry {
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
+	defer c.unlock()
 
-	if e, ok := c.entries[key]; ok {
-		c.use.Touch(key)
-		return e
-	} else {
+	i, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	now := c.clock.Now()
+	if c.expireWithLock(key, i, now) {
 		return nil
 	}
+	c.accessWithLock(key, i, now)
+	return i.entry
 }
 
-// Put puts an entry to the cache.
+// GetStale looks up an expired entry kept for serving stale, see SetMaxStale.
+// It returns the entry and how long it has expired, or nil if the key is not
+// found, not expired, or expired longer than maxStale.
+func (c *Cache) GetStale(key string) (*Entry, time.Duration) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	i, ok := c.entries[key]
+	if !ok {
+		return nil, 0
+	}
+	now := c.clock.Now()
+	if !c.expireWithLock(key, i, now) {
+		return nil, 0
+	}
+	// without LazyExpiration, entries not kept for serving stale are left to
+	// the cleaner, so staleness is checked here.
+	staleness := now.Sub(i.expire)
+	if c.maxStale <= 0 || staleness > c.maxStale {
+		return nil, 0
+	}
+	if _, ok := c.entries[key]; !ok {
+		return nil, 0
+	}
+	c.accessWithLock(key, i, now)
+	return i.entry, staleness
+}
+
+// Put puts an entry to the cache, and assigns a new version to it.
 // It marks the key as rencently-used.
 func (c *Cache) Put(entry *Entry) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	c.putWithLock(entry)
+}
+
+// Add puts an entry to the cache like Put, only if the key is not in cache or
+// expired. It returns the entry in cache after adding.
+func (c *Cache) Add(entry *Entry) *Entry {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	now := c.clock.Now()
+	if i, ok := c.entries[entry.Key]; ok && !i.expired(now) {
+		c.accessWithLock(entry.Key, i, now)
+		return i.entry
+	}
+	c.putWithLock(entry)
+	return entry
+}
+
+// AddUnless adds an entry like Add, unless skip returns true, in which case
+// it returns nil. skip is called with Cache locked, after the key is found
+// not in cache, so it must not call Cache's methods.
+func (c *Cache) AddUnless(entry *Entry, skip func() bool) *Entry {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	now := c.clock.Now()
+	if i, ok := c.entries[entry.Key]; ok && !i.expired(now) {
+		c.accessWithLock(entry.Key, i, now)
+		return i.entry
+	}
+	if skip() {
+		return nil
+	}
+	c.putWithLock(entry)
+	return entry
+}
+
+// CompareAndSwap replaces the value of a cached entry, only if its version
+// equals to the provided version.
+// It returns the new entry, and whether the swap is done.
+func (c *Cache) CompareAndSwap(key string, version uint64, value []byte) (*Entry, bool) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	i, ok := c.entries[key]
+	if !ok || i.entry.Version != version {
+		return nil, false
+	}
+	if c.expireWithLock(key, i, c.clock.Now()) {
+		return nil, false
+	}
+
+	entry := &Entry{Key: key, Value: value}
+	c.putWithLock(entry)
+	return entry, true
+}
+
+// Delete removes an entry from the cache.
+// It returns a deletion entry with a new version, which can be put into Buffer
+// to delete the key from database.
+func (c *Cache) Delete(key string) *Entry {
+	return c.DeleteFunc(key, nil)
+}
+
+// DeleteFunc removes an entry like Delete, and calls f with the deletion entry
+// before unlocking Cache, so the deletion can be put into Buffer before others
+// find the key missing. f must not call Cache's methods.
+func (c *Cache) DeleteFunc(key string, f func(deletion *Entry)) *Entry {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	c.deleteWithLock(key)
+	delete(c.leases, key)
+	c.version++
+	deletion := &Entry{Key: key, Version: c.version, Deleted: true}
+	if f != nil {
+		f(deletion)
+	}
+	return deletion
+}
+
+// Update replaces the value of an entry by calling f atomically.
+// f is called with the current value and whether the key is cached, it returns
+// the new value and whether to keep it. If keep is false, the cache is left
+// unchanged.
+// f is called with Cache locked, so it must not call Cache's methods.
+// It returns the new entry, and whether it is kept.
+func (c *Cache) Update(key string, f func(old []byte, exists bool) (value []byte, keep bool)) (*Entry, bool) {
+	return c.UpdateFunc(key, nil, f, nil)
+}
+
+// UpdateFunc replaces the value of an entry like Update. If the key is not
+// cached and seed is not nil, f is called with the value returned by seed
+// instead, so data not kept in cache can be changed atomically too. If the
+// new entry is kept, put is called with it before unlocking Cache, so it can be
+// put into Buffer before others find the key missing. seed and put must not
+// call Cache's methods.
+func (c *Cache) UpdateFunc(key string, seed func() (old []byte, exists bool), f func(old []byte, exists bool) (value []byte, keep bool), put func(entry *Entry)) (*Entry, bool) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	var old []byte
+	i, exists := c.entries[key]
+	if exists && c.expireWithLock(key, i, c.clock.Now()) {
+		exists = false
+	}
+	if exists {
+		old = i.entry.Value
+	} else if seed != nil {
+		old, exists = seed()
+	}
+
+	value, keep := f(old, exists)
+	if !keep {
+		return nil, false
+	}
+
+	entry := &Entry{Key: key, Value: value}
+	c.putWithLock(entry)
+	if put != nil {
+		put(entry)
+	}
+	return entry, true
+}
+
+// Version returns the version of the latest change to Cache. Entries put or
+// deleted afterwards have greater versions.
+func (c *Cache) Version() uint64 {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
-	c.entries[entry.Key] = entry
+	return c.version
+}
+
+func (c *Cache) putWithLock(entry *Entry) {
+	c.version++
+	entry.Version = c.version
+
+	now := c.clock.Now()
+	i := &item{entry: entry, created: now, accessed: now}
+	if c.ttl > 0 {
+		i.expire = now.Add(c.ttl)
+	}
+	if c.softTTL > 0 {
+		i.refresh = now.Add(c.softTTL)
+	}
+	q := c.quotaWithLock(entry.Key)
+	if old, ok := c.entries[entry.Key]; ok {
+		c.bytes -= old.entry.Size()
+		c.releaseWithLock(old)
+		i.hits = old.hits
+		i.recent = old.recent
+		if q != nil {
+			q.remove(entry.Key, old.entry.Size())
+		}
+	}
+	c.internWithLock(i)
+	c.entries[entry.Key] = i
+	c.bytes += entry.Size()
 	c.use.Touch(entry.Key)
+	c.scheduleWithLock(entry.Key, i)
+	delete(c.leases, entry.Key)
+	if q != nil {
+		q.add(entry.Key, entry.Size())
+		c.checkQuotaWithLock(q)
+	}
 	c.checkMaxEntryWithLock()
+	if c.expiration&LazyExpiration != 0 {
+		c.removeDueWithLock(now, removeDuePerPut)
+	}
+}
+
+// Touch refreshes the expiration of an entry to d from now, without changing
+// its value. It marks the key as recently-used.
+// If d is 0, the entry never expires.
+// It returns false if the key is not found or already expired.
+func (c *Cache) Touch(key string, d time.Duration) bool {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	i, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	now := c.clock.Now()
+	if c.expireWithLock(key, i, now) {
+		return false
+	}
+	if d > 0 {
+		i.expire = now.Add(d)
+	} else {
+		i.expire = time.Time{}
+	}
+	if c.softTTL > 0 {
+		i.refresh = now.Add(c.softTTL)
+	}
+	c.scheduleWithLock(key, i)
+	c.use.Touch(key)
+	return true
 }
 
 // MaxEntry returns maxEntry of the cache.
@@ -69,10 +389,108 @@ func (c *Cache) MaxEntry() int {
 	return c.maxEntry
 }
 
+// Clear removes all entries atomically.
+func (c *Cache) Clear() {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	if c.onEvict != nil {
+		for _, i := range c.entries {
+			c.evicted = append(c.evicted, i.entry)
+		}
+	}
+	c.entries = make(map[string]*item)
+	c.leases = make(map[string]*lease)
+	c.granted = nil
+	c.bytes = 0
+	c.use = lru.New()
+	if c.values != nil {
+		c.values = make(map[uint64]*sharedValue)
+	}
+	c.rebuildIndexWithLock()
+	c.rebuildQuotasWithLock()
+}
+
+// Len returns the number of entries in cache, including expired ones not
+// removed yet.
+func (c *Cache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return len(c.entries)
+}
+
+// EstimatedBytes returns the estimated memory used by entries, in bytes.
+func (c *Cache) EstimatedBytes() int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.bytes
+}
+
+// expireWithLock tells whether an item is expired, and removes it if it is
+// not kept for serving stale, with LazyExpiration.
+func (c *Cache) expireWithLock(key string, i *item, now time.Time) bool {
+	if !i.expired(now) {
+		return false
+	}
+	if c.expiration&LazyExpiration != 0 && c.dueWithLock(i, now) {
+		c.removeWithLock(key)
+	}
+	return true
+}
+
+// accessWithLock marks a key as recently-used, and counts a hit.
+func (c *Cache) accessWithLock(key string, i *item, now time.Time) {
+	c.use.Touch(key)
+	if q := c.quotaWithLock(key); q != nil {
+		q.use.Touch(key)
+	}
+	i.accessed = now
+	i.hits++
+	c.countHitWithLock(i, now)
+}
+
+// removeWithLock evicts an entry.
+func (c *Cache) removeWithLock(key string) {
+	if i, ok := c.deleteWithLock(key); ok && c.onEvict != nil {
+		c.evicted = append(c.evicted, i.entry)
+	}
+}
+
+func (c *Cache) deleteWithLock(key string) (*item, bool) {
+	i, ok := c.entries[key]
+	if ok {
+		delete(c.entries, key)
+		c.bytes -= i.entry.Size()
+		c.use.Remove(key)
+		c.releaseWithLock(i)
+		if q := c.quotaWithLock(key); q != nil {
+			q.remove(key, i.entry.Size())
+		}
+		if c.index != nil {
+			c.index.remove(key)
+		}
+	}
+	return i, ok
+}
+
+// unlock unlocks the cache, then calls onEvict for entries evicted while
+// holding the lock, so onEvict is free to call Cache methods.
+func (c *Cache) unlock() {
+	evicted, onEvict := c.evicted, c.onEvict
+	c.evicted = nil
+	c.mtx.Unlock()
+
+	for _, e := range evicted {
+		onEvict(e)
+	}
+}
+
 func (c *Cache) checkMaxEntryWithLock() {
 	for c.maxEntry > 0 && len(c.entries) > c.maxEntry {
 		if k, ok := c.use.Pop().(string); ok {
-			delete(c.entries, k)
+			c.removeWithLock(k)
 		} else {
 			break
 		}
@@ -81,8 +499,20 @@ func (c *Cache) checkMaxEntryWithLock() {
 
 // CacheStatus is used for runtime performance profiling.
 type CacheStatus struct {
-	MaxEntry  int `json:"maxEntry"`
-	CacheSize int `json:"cacheSize"`
+	MaxEntry   int   `json:"maxEntry"`
+	CacheSize  int   `json:"cacheSize"`
+	CacheBytes int64 `json:"cacheBytes"`
+
+	DoorkeeperRejected uint64 `json:"doorkeeperRejected"`
+	ExpiryScheduled    int    `json:"expiryScheduled"` // entries in expiry index
+	CleanerRuns        uint64 `json:"cleanerRuns"`
+	CleanerRemoved     uint64 `json:"cleanerRemoved"`
+	ShrunkEntries      uint64 `json:"shrunkEntries"`
+	RefreshTriggered   uint64 `json:"refreshTriggered"` // entries told to refresh, see SetSoftTTL
+	DedupValues        int    `json:"dedupValues"`      // distinct values shared
+	DedupSavedBytes    int64  `json:"dedupSavedBytes"`  // bytes saved by sharing values
+
+	Quotas map[string]QuotaStatus `json:"quotas,omitempty"`
 }
 
 // Status returns Cache's runtime performance status.
@@ -90,8 +520,30 @@ func (c *Cache) Status() CacheStatus {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
-	return CacheStatus{
-		MaxEntry:  c.maxEntry,
-		CacheSize: len(c.entries),
+	s := CacheStatus{
+		MaxEntry:   c.maxEntry,
+		CacheSize:  len(c.entries),
+		CacheBytes: c.bytes,
+
+		ShrunkEntries:    c.shrunk,
+		RefreshTriggered: c.refreshed,
+	}
+	if c.door != nil {
+		s.DoorkeeperRejected = c.door.rejected
+	}
+	if c.index != nil {
+		s.ExpiryScheduled = c.index.len()
+	}
+	s.DedupValues, s.DedupSavedBytes = c.dedupStatusWithLock()
+	if len(c.quotas) > 0 {
+		s.Quotas = make(map[string]QuotaStatus, len(c.quotas))
+		for ns, q := range c.quotas {
+			s.Quotas[ns] = q.status()
+		}
+	}
+	if c.cleaner != nil {
+		s.CleanerRuns = c.cleaner.runs
+		s.CleanerRemoved = c.cleaner.removed
 	}
+	return s
 }
@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/huangml/proxycache/clock"
+)
+
+func TestGetStale(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     Expiration
+		maxStale time.Duration
+		expired  time.Duration // how long expired when looked up
+		stale    bool          // whether served stale
+	}{
+		{"lazy", LazyExpiration, time.Minute, 30 * time.Second, true},
+		{"lazy disabled", LazyExpiration, 0, 30 * time.Second, false},
+		{"lazy past maxStale", LazyExpiration, time.Minute, 2 * time.Minute, false},
+		{"active", ActiveExpiration, time.Minute, 30 * time.Second, true},
+		{"active disabled", ActiveExpiration, 0, 10 * time.Minute, false},
+		{"active past maxStale", ActiveExpiration, time.Second, 20 * time.Minute, false},
+		{"active at maxStale", ActiveExpiration, time.Minute, time.Minute, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Unix(0, 0))
+			c := NewCache(0)
+			c.SetClock(clk)
+			c.SetTTL(time.Minute)
+			c.SetMaxStale(tt.maxStale)
+			// the cleaner does not run before the entry is looked up.
+			c.SetExpiration(tt.mode, time.Hour, 0)
+			defer c.SetExpiration(LazyExpiration, 0, 0)
+
+			c.Put(&Entry{Key: "k", Value: []byte("v")})
+			clk.Advance(time.Minute + tt.expired)
+
+			if e := c.Get("k"); e != nil {
+				t.Fatalf("Get() = %q after expired", e.Value)
+			}
+			e, staleness := c.GetStale("k")
+			if stale := e != nil; stale != tt.stale {
+				t.Fatalf("GetStale() served stale %v, want %v", stale, tt.stale)
+			}
+			if tt.stale && staleness != tt.expired {
+				t.Errorf("GetStale() staleness %v, want %v", staleness, tt.expired)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package cache
+
+import "time"
+
+// Expiration is how Cache removes expired entries, see SetExpiration.
+type Expiration int
+
+const (
+	// LazyExpiration removes expired entries once they are accessed. With an
+	// expiry index, a few due entries are also removed on each put.
+	LazyExpiration Expiration = 1 << iota
+	// ActiveExpiration removes expired entries by a cleaner goroutine.
+	ActiveExpiration
+	// BothExpiration combines LazyExpiration and ActiveExpiration.
+	BothExpiration = LazyExpiration | ActiveExpiration
+)
+
+type cleaner struct {
+	stop    chan struct{}
+	runs    uint64
+	removed uint64
+}
+
+// SetExpiration sets how expired entries are removed. The default is
+// LazyExpiration.
+// With ActiveExpiration, a cleaner removes expired entries every interval,
+// examining at most budget entries a run, or no limit if budget is 0. Only due
+// entries are examined with an expiry index, see SetExpiryIndex, otherwise
+// entries are sampled from the whole cache.
+// Without LazyExpiration, accessing an expired entry still misses, but the
+// entry is left to the cleaner.
+func (c *Cache) SetExpiration(mode Expiration, interval time.Duration, budget int) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	if c.cleaner != nil {
+		close(c.cleaner.stop)
+		c.cleaner = nil
+	}
+	c.expiration = mode
+	if mode&ActiveExpiration == 0 || interval <= 0 {
+		return
+	}
+
+	cl := &cleaner{stop: make(chan struct{})}
+	c.cleaner = cl
+
+	t := c.clock.NewTimer(interval)
+	go func() {
+		defer t.Stop()
+		for {
+			select {
+			case <-cl.stop:
+				return
+			case <-t.C():
+				c.mtx.Lock()
+				cl.runs++
+				cl.removed += uint64(c.removeExpiredWithLock(c.clock.Now(), budget))
+				c.unlock()
+				t.Reset(interval)
+			}
+		}
+	}()
+}
+
+// removeExpiredWithLock removes expired entries not kept for serving stale,
+// examining at most budget entries, no limit if budget is 0.
+// It returns the number of entries removed.
+func (c *Cache) removeExpiredWithLock(now time.Time, budget int) int {
+	if c.index != nil {
+		return c.removeDueWithLock(now, budget)
+	}
+
+	n, examined := 0, 0
+	for key, i := range c.entries {
+		if budget > 0 && examined >= budget {
+			break
+		}
+		examined++
+		if c.dueWithLock(i, now) {
+			c.removeWithLock(key)
+			n++
+		}
+	}
+	return n
+}
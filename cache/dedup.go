@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"bytes"
+	"hash/fnv"
+)
+
+// sharedValue is a value stored once for all entries with identical values.
+type sharedValue struct {
+	hash  uint64
+	value []byte
+	refs  int
+}
+
+// SetDedup enables storing identical values once, shared by all entries
+// having them, for values at least minSize bytes. It saves memory when many
+// keys map to a few values. If minSize is 0, dedup is disabled.
+// Values put are replaced by the shared ones, so they must not be modified.
+func (c *Cache) SetDedup(minSize int) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	c.dedupMin = minSize
+	c.values = nil
+	for _, i := range c.entries {
+		i.shared = nil
+	}
+	if minSize <= 0 {
+		return
+	}
+
+	c.values = make(map[uint64]*sharedValue)
+	for _, i := range c.entries {
+		c.internWithLock(i)
+	}
+}
+
+// internWithLock replaces the value of an item by the shared one.
+// Values colliding with a different shared value are kept unshared.
+func (c *Cache) internWithLock(i *item) {
+	value := i.entry.Value
+	if c.values == nil || len(value) < c.dedupMin {
+		return
+	}
+
+	h := fnv.New64a()
+	h.Write(value)
+	sum := h.Sum64()
+
+	s, ok := c.values[sum]
+	if !ok {
+		s = &sharedValue{hash: sum, value: value}
+		c.values[sum] = s
+	} else if !bytes.Equal(s.value, value) {
+		return
+	}
+	s.refs++
+	i.shared = s
+	i.entry.Value = s.value
+}
+
+// releaseWithLock drops the reference of an item to its shared value.
+func (c *Cache) releaseWithLock(i *item) {
+	s := i.shared
+	if s == nil {
+		return
+	}
+	i.shared = nil
+	s.refs--
+	if s.refs == 0 && c.values[s.hash] == s {
+		delete(c.values, s.hash)
+	}
+}
+
+// dedupStatusWithLock returns the number of shared values, and bytes saved by
+// sharing them.
+func (c *Cache) dedupStatusWithLock() (values int, saved int64) {
+	for _, s := range c.values {
+		saved += int64(len(s.value)) * int64(s.refs-1)
+	}
+	return len(c.values), saved
+}
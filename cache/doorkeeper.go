@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/huangml/proxycache/bloom"
+)
+
+// doorkeeper remembers keys accessed once in current window.
+type doorkeeper struct {
+	filter   *bloom.Filter
+	stop     chan struct{}
+	rejected uint64
+}
+
+// SetDoorkeeper enables a doorkeeper for Admit, so a key is only admitted on
+// its second access in a window. It keeps keys accessed only once, like a
+// scan, from polluting cache.
+// The doorkeeper holds about n keys with a false positive rate of fp, and is
+// reset every window. If n is 0, the doorkeeper is disabled.
+func (c *Cache) SetDoorkeeper(n int, fp float64, window time.Duration) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	if c.door != nil {
+		close(c.door.stop)
+		c.door = nil
+	}
+	if n <= 0 || window <= 0 {
+		return
+	}
+
+	d := &doorkeeper{
+		filter: bloom.New(n, fp),
+		stop:   make(chan struct{}),
+	}
+	c.door = d
+
+	t := c.clock.NewTimer(window)
+	go func() {
+		defer t.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-t.C():
+				c.mtx.Lock()
+				d.filter.Reset()
+				c.mtx.Unlock()
+				t.Reset(window)
+			}
+		}
+	}()
+}
+
+// Admit tells whether a key loaded from database should be put into cache.
+// With doorkeeper enabled, it returns false on the first access of a key in
+// the window, see SetDoorkeeper. Otherwise it returns true.
+func (c *Cache) Admit(key string) bool {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	d := c.door
+	if d == nil || d.filter.Test(key) {
+		return true
+	}
+	if d.filter.Len() >= d.filter.Cap() {
+		d.filter.Reset()
+	}
+	d.filter.Add(key)
+	d.rejected++
+	return false
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// ErrUnknownFormat is returned by Dump for a format not supported.
+var ErrUnknownFormat = errors.New("cache: unknown dump format")
+
+// DumpFormat is the format of Dump.
+type DumpFormat int
+
+const (
+	// DumpJSON dumps a JSON array of DumpRecord.
+	DumpJSON DumpFormat = iota
+	// DumpCSV dumps DumpRecord in CSV, with a header line.
+	DumpCSV
+)
+
+// DumpRecord describes an entry in cache. Times are unix epoch time.
+type DumpRecord struct {
+	Key      string `json:"key"`
+	Size     int64  `json:"size"`
+	Version  uint64 `json:"version"`
+	Created  int64  `json:"created"`
+	Accessed int64  `json:"accessed"`
+	Expire   int64  `json:"expire"` // 0 means never expire
+	TTL      int64  `json:"ttl"`    // seconds to expire, negative if expired
+	Hits     uint64 `json:"hits"`
+}
+
+var dumpHeader = []string{"key", "size", "version", "created", "accessed", "expire", "ttl", "hits"}
+
+// Dump writes all entries in cache to w, sorted by keys, including expired ones
+// not removed yet. Values are not written.
+// Entries are copied with Cache locked, and written after unlocking, so
+// writing to a slow w doesn't block the cache.
+func (c *Cache) Dump(w io.Writer, format DumpFormat) error {
+	if format != DumpJSON && format != DumpCSV {
+		return ErrUnknownFormat
+	}
+
+	records := c.dumpRecords()
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+
+	if format == DumpJSON {
+		return json.NewEncoder(w).Encode(records)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(dumpHeader)
+	for _, r := range records {
+		cw.Write([]string{
+			r.Key,
+			strconv.FormatInt(r.Size, 10),
+			strconv.FormatUint(r.Version, 10),
+			strconv.FormatInt(r.Created, 10),
+			strconv.FormatInt(r.Accessed, 10),
+			strconv.FormatInt(r.Expire, 10),
+			strconv.FormatInt(r.TTL, 10),
+			strconv.FormatUint(r.Hits, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (c *Cache) dumpRecords() []DumpRecord {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := c.clock.Now()
+	records := make([]DumpRecord, 0, len(c.entries))
+	for key, i := range c.entries {
+		m := metaOf(key, i, now)
+		r := DumpRecord{
+			Key:      m.Key,
+			Size:     m.Size,
+			Version:  m.Version,
+			Created:  m.Created.Unix(),
+			Accessed: m.Accessed.Unix(),
+			Hits:     m.Hits,
+		}
+		if !m.Expire.IsZero() {
+			r.Expire = m.Expire.Unix()
+			r.TTL = int64(m.TTL.Seconds())
+		}
+		records = append(records, r)
+	}
+	return records
+}
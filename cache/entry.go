@@ -1,7 +1,20 @@
 package cache
 
 // Entry is a Key-Value pair.
+// Entry should not be modified once it is put into Cache or Buffer.
 type Entry struct {
 	Key   string
 	Value []byte
+
+	// Version is assigned by Cache, it increases every time the key is put.
+	// Version 0 means the entry has never been put into a Cache.
+	Version uint64
+
+	// Deleted marks the entry as a deletion of the key, see Cache.Delete.
+	Deleted bool
+}
+
+// Size returns the estimated memory used by the entry, in bytes.
+func (e *Entry) Size() int64 {
+	return int64(len(e.Key) + len(e.Value))
 }
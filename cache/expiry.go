@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/huangml/proxycache/priority-queue/pq"
+	"github.com/huangml/proxycache/timerwheel"
+)
+
+// ExpiryIndex is the kind of index Cache uses to find expired entries.
+type ExpiryIndex int
+
+const (
+	// NoIndex finds expired entries only when they are accessed.
+	NoIndex ExpiryIndex = iota
+	// WheelIndex finds expired entries by a hierarchical timer wheel, it is
+	// cheap for large number of entries, at the cost of a coarse resolution.
+	WheelIndex
+	// HeapIndex finds expired entries by a min-heap of deadlines, it is exact,
+	// at the cost of O(log n) per put.
+	HeapIndex
+)
+
+// removeDuePerPut is the number of expired entries removed on each put, so
+// expired entries are removed faster than new entries come.
+const removeDuePerPut = 2
+
+// expiryIndex schedules keys by the time they should be removed.
+type expiryIndex interface {
+	schedule(key string, deadline time.Time)
+	remove(key string)
+	// due pops up to max keys whose deadlines have passed, no limit if max
+	// is 0. Keys popped may have been rescheduled, which should be checked.
+	due(now time.Time, max int) []string
+	len() int
+}
+
+// SetExpiryIndex sets the index to find expired entries, which are removed
+// a few at a time on each put. Parameter tick is the resolution of
+// WheelIndex, it is ignored by HeapIndex. With NoIndex, expired entries are
+// only removed when accessed or evicted.
+func (c *Cache) SetExpiryIndex(kind ExpiryIndex, tick time.Duration) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	c.indexKind, c.indexTick = kind, tick
+	c.rebuildIndexWithLock()
+}
+
+func (c *Cache) rebuildIndexWithLock() {
+	switch c.indexKind {
+	case WheelIndex:
+		c.index = newWheelIndex(c.indexTick, c.clock.Now())
+	case HeapIndex:
+		c.index = newHeapIndex()
+	default:
+		c.index = nil
+		return
+	}
+
+	for key, i := range c.entries {
+		c.scheduleWithLock(key, i)
+	}
+}
+
+// deadline returns when an item should be removed, zero if never.
+func (c *Cache) deadline(i *item) time.Time {
+	if i.expire.IsZero() || c.maxStale <= 0 {
+		return i.expire
+	}
+	return i.expire.Add(c.maxStale)
+}
+
+// dueWithLock tells whether an item should be removed by now.
+func (c *Cache) dueWithLock(i *item, now time.Time) bool {
+	d := c.deadline(i)
+	return !d.IsZero() && !now.Before(d)
+}
+
+func (c *Cache) scheduleWithLock(key string, i *item) {
+	if c.index == nil {
+		return
+	}
+	if d := c.deadline(i); d.IsZero() {
+		c.index.remove(key)
+	} else {
+		c.index.schedule(key, d)
+	}
+}
+
+// removeDueWithLock removes up to max entries due, no limit if max is 0.
+// It returns the number of entries removed.
+func (c *Cache) removeDueWithLock(now time.Time, max int) int {
+	if c.index == nil {
+		return 0
+	}
+
+	n := 0
+	for _, key := range c.index.due(now, max) {
+		if i, ok := c.entries[key]; ok && c.dueWithLock(i, now) {
+			c.removeWithLock(key)
+			n++
+		}
+	}
+	return n
+}
+
+type wheelIndex struct {
+	w       *timerwheel.Wheel
+	pending []string // due but not popped yet
+}
+
+func newWheelIndex(tick time.Duration, now time.Time) *wheelIndex {
+	return &wheelIndex{w: timerwheel.New(tick, now)}
+}
+
+func (x *wheelIndex) schedule(key string, deadline time.Time) {
+	x.w.Schedule(key, deadline)
+}
+
+func (x *wheelIndex) remove(key string) {
+	x.w.Remove(key)
+}
+
+func (x *wheelIndex) due(now time.Time, max int) []string {
+	for _, v := range x.w.Advance(now) {
+		x.pending = append(x.pending, v.(string))
+	}
+
+	n := len(x.pending)
+	if max > 0 && max < n {
+		n = max
+	}
+	keys := x.pending[:n:n]
+	x.pending = x.pending[n:]
+	return keys
+}
+
+func (x *wheelIndex) len() int {
+	return x.w.Len() + len(x.pending)
+}
+
+type heapIndex struct {
+	q *pq.PriorityQueue // keys by deadlines in unix nanoseconds
+}
+
+func newHeapIndex() *heapIndex {
+	return &heapIndex{q: pq.New()}
+}
+
+func (x *heapIndex) schedule(key string, deadline time.Time) {
+	x.q.Push(key, deadline.UnixNano())
+}
+
+func (x *heapIndex) remove(key string) {
+	x.q.Remove(key)
+}
+
+func (x *heapIndex) due(now time.Time, max int) []string {
+	var keys []string
+	for max <= 0 || len(keys) < max {
+		key, deadline := x.q.Peek()
+		if key == nil || deadline > now.UnixNano() {
+			break
+		}
+		keys = append(keys, x.q.Pop().(string))
+	}
+	return keys
+}
+
+func (x *heapIndex) len() int {
+	return x.q.Len()
+}
@@ -0,0 +1,84 @@
+package cache
+
+import "time"
+
+// LeaseTimeout is how long a lease keeps valid.
+const LeaseTimeout = 10 * time.Second
+
+type lease struct {
+	key    string
+	token  uint64
+	expire time.Time
+}
+
+// Lease looks up entry by a key like Get.
+// If the key is not cached, a lease token is returned, only the lease holder
+// is allowed to put the key by PutLeased. Putting or deleting the key voids
+// the lease.
+// Only one lease of a key is handed out at a time, so if both entry and token
+// are empty, the key is being leased by others, the caller should try again
+// later.
+func (c *Cache) Lease(key string) (entry *Entry, token uint64) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	now := c.clock.Now()
+	if i, ok := c.entries[key]; ok && !c.expireWithLock(key, i, now) {
+		c.accessWithLock(key, i, now)
+		return i.entry, 0
+	}
+
+	if l, ok := c.leases[key]; ok && now.Before(l.expire) {
+		return nil, 0
+	}
+
+	c.pruneLeasesWithLock(now)
+	c.version++
+	l := &lease{
+		key:    key,
+		token:  c.version,
+		expire: now.Add(LeaseTimeout),
+	}
+	c.leases[key] = l
+	c.granted = append(c.granted, l)
+	return nil, c.version
+}
+
+// pruneLeasesWithLock removes leases expired, which are never put or
+// unleased. Leases expire in the order they are granted, so only the oldest
+// ones are examined.
+func (c *Cache) pruneLeasesWithLock(now time.Time) {
+	for len(c.granted) > 0 && !now.Before(c.granted[0].expire) {
+		l := c.granted[0]
+		if c.leases[l.key] == l {
+			delete(c.leases, l.key)
+		}
+		c.granted[0] = nil
+		c.granted = c.granted[1:]
+	}
+}
+
+// PutLeased puts an entry like Put, only if token is the valid lease of the
+// key. It returns false if the lease is voided or expired.
+func (c *Cache) PutLeased(entry *Entry, token uint64) bool {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	l, ok := c.leases[entry.Key]
+	if !ok || l.token != token || !c.clock.Now().Before(l.expire) {
+		return false
+	}
+
+	c.putWithLock(entry)
+	return true
+}
+
+// Unlease gives up a lease without putting the key.
+func (c *Cache) Unlease(key string, token uint64) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	if l, ok := c.leases[key]; ok && l.token == token {
+		delete(c.leases, key)
+	}
+}
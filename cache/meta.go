@@ -0,0 +1,45 @@
+package cache
+
+import "time"
+
+// EntryMeta is the metadata of an entry in cache.
+type EntryMeta struct {
+	Key      string
+	Size     int64
+	Version  uint64
+	Created  time.Time
+	Accessed time.Time
+	Expire   time.Time     // zero means never expire
+	TTL      time.Duration // remaining before expiring, not positive if expired
+	Hits     uint64        // of the key, kept across puts
+}
+
+// Meta returns the metadata of the entry of key, without marking it as
+// recently-used or counting a hit. Expired entries not removed yet are
+// returned too. It returns false if the key is not cached.
+func (c *Cache) Meta(key string) (EntryMeta, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	i, ok := c.entries[key]
+	if !ok {
+		return EntryMeta{}, false
+	}
+	return metaOf(key, i, c.clock.Now()), true
+}
+
+func metaOf(key string, i *item, now time.Time) EntryMeta {
+	m := EntryMeta{
+		Key:      key,
+		Size:     i.entry.Size(),
+		Version:  i.entry.Version,
+		Created:  i.created,
+		Accessed: i.accessed,
+		Expire:   i.expire,
+		Hits:     i.hits,
+	}
+	if !i.expire.IsZero() {
+		m.TTL = i.expire.Sub(now)
+	}
+	return m
+}
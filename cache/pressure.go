@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"runtime"
+	"time"
+)
+
+// SetMemoryLimit enables evicting by memory pressure. Heap usage is checked
+// every interval, and if it is over limit in bytes, the least-recently-used
+// fraction of entries are evicted, see Shrink.
+// Reading memory stats stops the world for a short while, so interval should
+// not be too small. If limit is 0, evicting by memory pressure is disabled.
+func (c *Cache) SetMemoryLimit(limit uint64, interval time.Duration, fraction float64) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	if c.stopPressure != nil {
+		close(c.stopPressure)
+		c.stopPressure = nil
+	}
+	if limit == 0 || interval <= 0 || fraction <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	c.stopPressure = stop
+
+	t := c.clock.NewTimer(interval)
+	go func() {
+		defer t.Stop()
+
+		var m runtime.MemStats
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C():
+				runtime.ReadMemStats(&m)
+				if m.HeapAlloc > limit {
+					c.Shrink(fraction)
+				}
+				t.Reset(interval)
+			}
+		}
+	}()
+}
+
+// Shrink evicts the least-recently-used fraction of entries, at least one if
+// the cache is not empty. It can be called on memory pressure signals from
+// outside, like a container approaching its memory limit.
+// It returns the number of entries evicted.
+func (c *Cache) Shrink(fraction float64) int {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	if fraction > 1 {
+		fraction = 1
+	}
+	n := int(float64(len(c.entries)) * fraction)
+	if n == 0 && fraction > 0 && len(c.entries) > 0 {
+		n = 1
+	}
+
+	evicted := 0
+	for ; evicted < n; evicted++ {
+		k, ok := c.use.Pop().(string)
+		if !ok {
+			break
+		}
+		c.removeWithLock(k)
+	}
+	c.shrunk += uint64(evicted)
+	return evicted
+}
@@ -0,0 +1,118 @@
+package cache
+
+import "github.com/huangml/proxycache/lru"
+
+// quota limits entries of a namespace, which are evicted by their own LRU
+// order when the namespace is full.
+type quota struct {
+	maxEntry int
+	maxBytes int64
+	entries  int
+	bytes    int64
+	use      *lru.LRU
+	evicted  uint64
+}
+
+func (q *quota) add(key string, size int64) {
+	q.entries++
+	q.bytes += size
+	q.use.Touch(key)
+}
+
+func (q *quota) remove(key string, size int64) {
+	q.entries--
+	q.bytes -= size
+	q.use.Remove(key)
+}
+
+func (q *quota) full() bool {
+	return (q.maxEntry > 0 && q.entries > q.maxEntry) ||
+		(q.maxBytes > 0 && q.bytes > q.maxBytes)
+}
+
+// SetNamespace sets the function telling which namespace a key belongs to, see
+// SetQuota. f is called with Cache locked, so it must not call Cache's methods.
+func (c *Cache) SetNamespace(f func(key string) string) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	c.namespace = f
+	c.rebuildQuotasWithLock()
+}
+
+// SetQuota limits entries of a namespace to maxEntry entries and maxBytes
+// bytes, see EstimatedBytes. If a namespace is over its quota, its
+// least-recently-used entries are evicted, without affecting other
+// namespaces. If both maxEntry and maxBytes are 0, the quota is removed.
+// Namespaces are told by the function set by SetNamespace.
+func (c *Cache) SetQuota(namespace string, maxEntry int, maxBytes int64) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	if maxEntry <= 0 && maxBytes <= 0 {
+		delete(c.quotas, namespace)
+		return
+	}
+	if q, ok := c.quotas[namespace]; ok {
+		q.maxEntry, q.maxBytes = maxEntry, maxBytes
+		c.checkQuotaWithLock(q)
+		return
+	}
+
+	c.quotas[namespace] = &quota{maxEntry: maxEntry, maxBytes: maxBytes}
+	c.rebuildQuotasWithLock()
+}
+
+// rebuildQuotasWithLock counts entries of every quota again.
+// The LRU order within namespaces is lost.
+func (c *Cache) rebuildQuotasWithLock() {
+	for _, q := range c.quotas {
+		q.entries, q.bytes, q.use = 0, 0, lru.New()
+	}
+	for key, i := range c.entries {
+		if q := c.quotaWithLock(key); q != nil {
+			q.add(key, i.entry.Size())
+		}
+	}
+	for _, q := range c.quotas {
+		c.checkQuotaWithLock(q)
+	}
+}
+
+// quotaWithLock returns the quota of a key, or nil if it has none.
+func (c *Cache) quotaWithLock(key string) *quota {
+	if c.namespace == nil || len(c.quotas) == 0 {
+		return nil
+	}
+	return c.quotas[c.namespace(key)]
+}
+
+func (c *Cache) checkQuotaWithLock(q *quota) {
+	for q.full() {
+		k, ok := q.use.Pop().(string)
+		if !ok {
+			break
+		}
+		c.removeWithLock(k)
+		q.evicted++
+	}
+}
+
+// QuotaStatus is the usage of a namespace quota.
+type QuotaStatus struct {
+	MaxEntry int    `json:"maxEntry"`
+	MaxBytes int64  `json:"maxBytes"`
+	Entries  int    `json:"entries"`
+	Bytes    int64  `json:"bytes"`
+	Evicted  uint64 `json:"evicted"`
+}
+
+func (q *quota) status() QuotaStatus {
+	return QuotaStatus{
+		MaxEntry: q.maxEntry,
+		MaxBytes: q.maxBytes,
+		Entries:  q.entries,
+		Bytes:    q.bytes,
+		Evicted:  q.evicted,
+	}
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxEntry int
+		maxBytes int64
+		ops      string // "put <key>" or "get <key>", values are 1 byte
+		want     string // keys cached
+		evicted  uint64
+	}{
+		{"entries", 2, 0, "put a:1, put a:2, put a:3", "a:2, a:3", 1},
+		{"by LRU", 2, 0, "put a:1, put a:2, get a:1, put a:3", "a:1, a:3", 1},
+		{"bytes", 0, 8, "put a:1, put a:2, put a:3", "a:2, a:3", 1},
+		{"entry over bytes", 0, 3, "put a:1", "", 1},
+		{"others not affected", 1, 0, "put b:1, put b:2, put a:1, put a:2, put c", "a:2, b:1, b:2, c", 1},
+		{"put again", 2, 0, "put a:1, put a:2, put a:1, put a:1", "a:1, a:2", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(100)
+			c.SetNamespace(func(key string) string {
+				return strings.SplitN(key, ":", 2)[0]
+			})
+			c.SetQuota("a", tt.maxEntry, tt.maxBytes)
+
+			for _, op := range strings.Split(tt.ops, ", ") {
+				key := op[4:]
+				if strings.HasPrefix(op, "put ") {
+					c.Put(&Entry{Key: key, Value: []byte("v")})
+				} else {
+					c.Get(key)
+				}
+			}
+
+			if got := cachedKeys(c); got != tt.want {
+				t.Errorf("cached %q, want %q", got, tt.want)
+			}
+			if s := c.Status().Quotas["a"]; s.Evicted != tt.evicted {
+				t.Errorf("%d evicted, want %d", s.Evicted, tt.evicted)
+			}
+		})
+	}
+}
+
+func TestQuotaStatus(t *testing.T) {
+	c := NewCache(100)
+	c.Put(&Entry{Key: "a:1", Value: []byte("v")})
+	c.Put(&Entry{Key: "a:2", Value: []byte("v")})
+	c.Put(&Entry{Key: "b:1", Value: []byte("v")})
+
+	// quotas set later count entries cached.
+	c.SetQuota("a", 3, 100)
+	c.SetNamespace(func(key string) string {
+		return strings.SplitN(key, ":", 2)[0]
+	})
+	want := QuotaStatus{MaxEntry: 3, MaxBytes: 100, Entries: 2, Bytes: 8}
+	if s := c.Status().Quotas["a"]; !reflect.DeepEqual(s, want) {
+		t.Errorf("Status() of quota = %+v, want %+v", s, want)
+	}
+
+	c.SetQuota("a", 1, 0)
+	want = QuotaStatus{MaxEntry: 1, Entries: 1, Bytes: 4, Evicted: 1}
+	if s := c.Status().Quotas["a"]; !reflect.DeepEqual(s, want) {
+		t.Errorf("Status() of quota shrunk = %+v, want %+v", s, want)
+	}
+
+	c.Delete("a:1")
+	c.Delete("a:2")
+	if s := c.Status().Quotas["a"]; s.Entries != 0 || s.Bytes != 0 {
+		t.Errorf("Status() of quota emptied = %+v", s)
+	}
+
+	c.SetQuota("a", 0, 0)
+	if _, ok := c.Status().Quotas["a"]; ok {
+		t.Error("quota not removed")
+	}
+}
+
+// cachedKeys returns keys in c, sorted and joined by ", ".
+func cachedKeys(c *Cache) string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var keys []string
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
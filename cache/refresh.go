@@ -0,0 +1,40 @@
+package cache
+
+import "time"
+
+// SetSoftTTL sets the soft time-to-live of entries put afterwards. After the
+// soft ttl, an entry is still served, but should be refreshed, see
+// GetRefresh. After the ttl set by SetTTL, the hard one, it is never served.
+// If soft is 0, entries are never refreshed.
+func (c *Cache) SetSoftTTL(soft time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.softTTL = soft
+}
+
+// GetRefresh looks up entry by a key like Get, and tells whether it has passed
+// its soft ttl and should be refreshed, see SetSoftTTL.
+// Only one caller is told to refresh an entry, others are told again only if
+// the entry is not put in LeaseTimeout.
+func (c *Cache) GetRefresh(key string) (entry *Entry, refresh bool) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	i, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	now := c.clock.Now()
+	if c.expireWithLock(key, i, now) {
+		return nil, false
+	}
+	c.accessWithLock(key, i, now)
+
+	if !i.refresh.IsZero() && !now.Before(i.refresh) {
+		i.refresh = now.Add(LeaseTimeout)
+		c.refreshed++
+		return i.entry, true
+	}
+	return i.entry, false
+}
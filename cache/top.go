@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultHotWindow is the default window of recent hits, see SetHotWindow.
+const DefaultHotWindow = time.Minute
+
+// recentHits counts hits of the current window and the previous one.
+type recentHits struct {
+	window int64 // index of the current window
+	cur    uint64
+	prev   uint64
+}
+
+func (r *recentHits) roll(window int64) {
+	if r.window == window {
+		return
+	}
+	if r.window == window-1 {
+		r.prev = r.cur
+	} else {
+		r.prev = 0
+	}
+	r.cur = 0
+	r.window = window
+}
+
+func (c *Cache) windowWithLock(now time.Time) int64 {
+	return now.UnixNano() / int64(c.hotWindow)
+}
+
+func (c *Cache) countHitWithLock(i *item, now time.Time) {
+	i.recent.roll(c.windowWithLock(now))
+	i.recent.cur++
+}
+
+// SetHotWindow sets the window of recent hits reported by TopKeys. Hits are
+// counted in fixed windows, the recent hits of a key are those of the current
+// window and the previous one.
+func (c *Cache) SetHotWindow(window time.Duration) {
+	if window <= 0 {
+		window = DefaultHotWindow
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.hotWindow = window
+	for _, i := range c.entries {
+		i.recent = recentHits{}
+	}
+}
+
+// KeyStat is the recent hits and size of a key.
+type KeyStat struct {
+	Key  string `json:"key"`
+	Hits uint64 `json:"hits"` // in the recent window, see SetHotWindow
+	Size int64  `json:"size"`
+}
+
+// TopKeys is the report of TopKeys.
+type TopKeys struct {
+	Hottest []KeyStat `json:"hottest"` // by recent hits, keys not hit are excluded
+	Largest []KeyStat `json:"largest"` // by size
+}
+
+// TopKeys returns the top n keys by recent hits, and by size, of entries in
+// cache. Entries are copied with Cache locked, and ranked after unlocking.
+// If n is 0, the report is empty.
+func (c *Cache) TopKeys(n int) TopKeys {
+	if n <= 0 {
+		return TopKeys{}
+	}
+	stats := c.keyStats()
+
+	var top TopKeys
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Hits != stats[j].Hits {
+			return stats[i].Hits > stats[j].Hits
+		}
+		return stats[i].Key < stats[j].Key
+	})
+	for _, s := range stats {
+		if len(top.Hottest) >= n || s.Hits == 0 {
+			break
+		}
+		top.Hottest = append(top.Hottest, s)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Size != stats[j].Size {
+			return stats[i].Size > stats[j].Size
+		}
+		return stats[i].Key < stats[j].Key
+	})
+	if len(stats) > n {
+		stats = stats[:n]
+	}
+	top.Largest = append([]KeyStat(nil), stats...)
+	return top
+}
+
+func (c *Cache) keyStats() []KeyStat {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	window := c.windowWithLock(c.clock.Now())
+	stats := make([]KeyStat, 0, len(c.entries))
+	for key, i := range c.entries {
+		i.recent.roll(window)
+		stats = append(stats, KeyStat{
+			Key:  key,
+			Hits: i.recent.cur + i.recent.prev,
+			Size: i.entry.Size(),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/huangml/proxycache/clock"
+)
+
+func TestTopKeys(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	c := NewCache(100)
+	c.SetClock(clk)
+	c.SetHotWindow(time.Minute)
+
+	c.Put(&Entry{Key: "a", Value: []byte("1")})
+	c.Put(&Entry{Key: "b", Value: []byte("12")})
+	c.Put(&Entry{Key: "c", Value: []byte("123")})
+	c.Put(&Entry{Key: "d", Value: []byte("123")})
+	for key, hits := range map[string]int{"a": 3, "b": 1, "c": 1} {
+		for i := 0; i < hits; i++ {
+			c.Get(key)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		n       int
+		want    TopKeys
+	}{
+		{"none", 0, 0, TopKeys{}},
+		{"negative", 0, -1, TopKeys{}},
+		{"top 2", 0, 2, TopKeys{
+			Hottest: []KeyStat{{"a", 3, 2}, {"b", 1, 3}},
+			Largest: []KeyStat{{"c", 1, 4}, {"d", 0, 4}},
+		}},
+		{"all", 0, 10, TopKeys{
+			Hottest: []KeyStat{{"a", 3, 2}, {"b", 1, 3}, {"c", 1, 4}},
+			Largest: []KeyStat{{"c", 1, 4}, {"d", 0, 4}, {"b", 1, 3}, {"a", 3, 2}},
+		}},
+		{"previous window", time.Minute, 1, TopKeys{
+			Hottest: []KeyStat{{"a", 3, 2}},
+			Largest: []KeyStat{{"c", 1, 4}},
+		}},
+		{"cooled down", time.Minute, 1, TopKeys{
+			Largest: []KeyStat{{"c", 0, 4}},
+		}},
+	}
+
+	for _, tt := range tests {
+		clk.Advance(tt.advance)
+		if got := c.TopKeys(tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: TopKeys(%d) = %+v, want %+v", tt.name, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package cache
+
+import "time"
+
+// Tx is a transaction on Cache, see Cache.Txn.
+type Tx struct {
+	c      *Cache
+	now    time.Time
+	writes map[string][]byte
+	keys   []string // written keys, in order
+}
+
+// Get looks up the value of a key in transaction, values put in transaction
+// are visible. It marks the key as recently-used.
+func (tx *Tx) Get(key string) ([]byte, bool) {
+	if v, ok := tx.writes[key]; ok {
+		return v, true
+	}
+
+	i, ok := tx.c.entries[key]
+	if !ok || i.expired(tx.now) {
+		return nil, false
+	}
+	tx.c.accessWithLock(key, i, tx.now)
+	return i.entry.Value, true
+}
+
+// Put puts a value in transaction. It will be visible to others after the
+// transaction is committed.
+func (tx *Tx) Put(key string, value []byte) {
+	if _, ok := tx.writes[key]; !ok {
+		tx.keys = append(tx.keys, key)
+	}
+	tx.writes[key] = value
+}
+
+// Keys returns the keys put in transaction, in order.
+func (tx *Tx) Keys() []string {
+	return tx.keys
+}
+
+// Txn calls f with a transaction atomically, no other operations can be done
+// on Cache until f returns.
+// If f returns nil, all values put in transaction are committed to Cache,
+// otherwise they are discarded and the error is returned.
+// f is called with Cache locked, so it must not call Cache's methods.
+// It returns the committed entries.
+func (c *Cache) Txn(f func(tx *Tx) error) ([]*Entry, error) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	tx := &Tx{
+		c:      c,
+		now:    c.clock.Now(),
+		writes: make(map[string][]byte),
+	}
+	if err := f(tx); err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(tx.keys))
+	for _, key := range tx.keys {
+		entry := &Entry{Key: key, Value: tx.writes[key]}
+		c.putWithLock(entry)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
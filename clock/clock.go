@@ -0,0 +1,151 @@
+// package clock abstracts time, so time-based behaviors can be tested by
+// advancing a fake clock instead of sleeping.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells time and creates timers.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	After(d time.Duration) <-chan time.Time
+}
+
+// Timer is like time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Real is the Clock of package time.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+// Fake is a Clock whose time only moves by Advance or Set.
+// Timers fire when the time reaches their deadlines.
+type Fake struct {
+	mtx    sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]struct{}
+}
+
+// NewFake creates a Fake clock starting at now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now:    now,
+		timers: make(map[*fakeTimer]struct{}),
+	}
+}
+
+// Now returns the current time of the clock.
+func (f *Fake) Now() time.Time {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return f.now
+}
+
+// NewTimer creates a Timer firing after d of the clock's time.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{f: f, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// After is like time.After.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+// Advance moves the clock forward by d, firing timers due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mtx.Lock()
+	now := f.now.Add(d)
+	f.mtx.Unlock()
+
+	f.Set(now)
+}
+
+// Set sets the clock's time, firing timers due. Time never goes backward, an
+// earlier time is ignored.
+func (f *Fake) Set(now time.Time) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if now.Before(f.now) {
+		return
+	}
+	f.now = now
+	for t := range f.timers {
+		if !now.Before(t.deadline) {
+			delete(f.timers, t)
+			select {
+			case t.c <- now:
+			default:
+			}
+		}
+	}
+}
+
+// Timers returns the number of timers not fired or stopped yet. Tests can wait
+// for it before advancing, to make sure a goroutine has armed its timer.
+func (f *Fake) Timers() int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return len(f.timers)
+}
+
+type fakeTimer struct {
+	f        *Fake
+	c        chan time.Time
+	deadline time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.f.mtx.Lock()
+	defer t.f.mtx.Unlock()
+
+	_, active := t.f.timers[t]
+	delete(t.f.timers, t)
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.f.mtx.Lock()
+	defer t.f.mtx.Unlock()
+
+	_, active := t.f.timers[t]
+	t.deadline = t.f.now.Add(d)
+	if d <= 0 {
+		delete(t.f.timers, t)
+		select {
+		case t.c <- t.f.now:
+		default:
+		}
+		return active
+	}
+	t.f.timers[t] = struct{}{}
+	return active
+}
@@ -0,0 +1,72 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func fired(t Timer) bool {
+	select {
+	case <-t.C():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	start := time.Unix(0, 0)
+	f := NewFake(start)
+	timer := f.NewTimer(time.Second)
+	stopped := f.NewTimer(time.Second)
+	reset := f.NewTimer(time.Second)
+	after := f.After(2 * time.Second)
+
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("Stop() should report whether the timer was active")
+	}
+	if !reset.Reset(3 * time.Second) {
+		t.Fatal("Reset() = false of an active timer")
+	}
+	if f.Timers() != 3 {
+		t.Fatalf("Timers() = %d, want 3", f.Timers())
+	}
+
+	f.Advance(time.Second - 1)
+	if fired(timer) {
+		t.Fatal("timer fired before its deadline")
+	}
+	f.Advance(1)
+	if !fired(timer) {
+		t.Fatal("timer not fired at its deadline")
+	}
+	if fired(stopped) || fired(reset) {
+		t.Fatal("stopped or reset timer fired")
+	}
+
+	f.Set(start) // time never goes backward
+	if !f.Now().Equal(start.Add(time.Second)) {
+		t.Fatalf("Now() = %v after set backward", f.Now())
+	}
+	f.Advance(time.Second)
+	select {
+	case now := <-after:
+		if !now.Equal(start.Add(2 * time.Second)) {
+			t.Errorf("After() fired at %v", now)
+		}
+	default:
+		t.Fatal("After() not fired at its deadline")
+	}
+	f.Advance(time.Second)
+	if !fired(reset) {
+		t.Fatal("reset timer not fired at its new deadline")
+	}
+	if f.Timers() != 0 {
+		t.Errorf("Timers() = %d after all fired", f.Timers())
+	}
+
+	timer.Reset(0)
+	if !fired(timer) {
+		t.Error("timer reset to 0 not fired immediately")
+	}
+}
@@ -31,6 +31,12 @@ func main() {
 		} else {
 			put(os.Args[2], os.Args[3], os.Args[4])
 		}
+	case "delete":
+		if len(os.Args) < 3 {
+			fmt.Println("not enough args")
+			os.Exit(1)
+		}
+		del(os.Args[2])
 	case "status":
 		status()
 	default:
@@ -90,6 +96,27 @@ func put(key string, value string, ttw string) {
 	}
 }
 
+func del(key string) {
+	fmt.Println("Delete: ", key)
+
+	req, err := http.NewRequest("DELETE", URLBase+"keys/"+key, nil)
+	if err != nil {
+		fmt.Println("Fail build request")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Println("Error Status Code: ", resp.StatusCode)
+	} else {
+		fmt.Println("Delete OK.")
+	}
+}
+
 func status() {
 	rsp, err := httpClient.Get(URLBase + "status")
 	if err != nil {
@@ -35,3 +35,13 @@ func (d *InMemoryDB) Save(key string, value []byte) bool {
 	d.data[key] = value
 	return true
 }
+
+func (d *InMemoryDB) Delete(key string) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	fmt.Println("Deleting: ", key)
+
+	delete(d.data, key)
+	return true
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/huangml/proxycache/simulate"
+)
+
+func main() {
+	trace := flag.String("trace", "", "trace file in CSV of key,timestamp, or stdin if empty")
+	sizes := flag.String("sizes", "1000,10000,100000", "comma separated cache sizes")
+	policies := flag.String("policies", strings.Join(simulate.Policies, ","), "comma separated policies")
+	flag.Parse()
+
+	in := os.Stdin
+	if *trace != "" {
+		f, err := os.Open(*trace)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var ss []int
+	for _, s := range strings.Split(*sizes, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			fmt.Println("bad size:", s)
+			os.Exit(1)
+		}
+		ss = append(ss, n)
+	}
+
+	accesses, err := simulate.ReadTrace(in)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	results, err := simulate.Simulate(accesses, strings.Split(*policies, ","), ss)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-8s %10s %10s %10s %8s\n", "policy", "size", "hits", "misses", "ratio")
+	for _, r := range results {
+		fmt.Printf("%-8s %10d %10d %10d %8.4f\n", r.Policy, r.Size, r.Hits, r.Misses, r.HitRatio)
+	}
+}
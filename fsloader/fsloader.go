@@ -0,0 +1,211 @@
+// package fsloader implements a ProxyLoader loading files under a directory.
+package fsloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huangml/proxycache/clock"
+)
+
+// Loader loads the file of a key, which is a slash-separated path relative to
+// root. Keys not clean, like "a/../b", "./a" or "/a", are never loaded, and
+// neither are files resolved outside root by symbolic links.
+// Files loaded can be watched for changes, see SetWatch.
+type Loader struct {
+	root string
+
+	mtx       sync.Mutex
+	files     map[string]fileStat // files loaded, if watching
+	onChange  func(key string)
+	clock     clock.Clock
+	stopWatch chan struct{}
+}
+
+type fileStat struct {
+	size    int64
+	modTime time.Time
+}
+
+func statOf(fi os.FileInfo) fileStat {
+	return fileStat{size: fi.Size(), modTime: fi.ModTime()}
+}
+
+func (s fileStat) equal(o fileStat) bool {
+	return s.size == o.size && s.modTime.Equal(o.modTime)
+}
+
+// New creates a Loader of files under root.
+func New(root string) *Loader {
+	return &Loader{
+		root:  root,
+		files: make(map[string]fileStat),
+		clock: clock.Real,
+	}
+}
+
+// Path returns the path of the file of key, with symbolic links resolved. It
+// returns false if the key is not a clean relative path, or the file is
+// resolved outside root.
+func (l *Loader) Path(key string) (string, bool) {
+	if key == "" || strings.ContainsRune(key, 0) || path.IsAbs(key) ||
+		path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return "", false
+	}
+	p := filepath.Join(l.root, filepath.FromSlash(key))
+
+	root, err := filepath.EvalSymlinks(l.root)
+	if err != nil {
+		return "", false
+	}
+	resolved, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return p, true
+		}
+		return "", false
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return resolved, true
+}
+
+// Load loads the content of the file of key. Directories are not loaded.
+// The file opened must be the one checked by Path, so a file replaced by a
+// symbolic link meanwhile is not loaded.
+func (l *Loader) Load(key string) ([]byte, bool) {
+	p, ok := l.Path(key)
+	if !ok {
+		return nil, false
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil, false
+	}
+	if li, err := os.Lstat(p); err != nil || !os.SameFile(fi, li) {
+		return nil, false
+	}
+	value, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, false
+	}
+
+	l.mtx.Lock()
+	if l.stopWatch != nil {
+		l.files[key] = statOf(fi)
+	}
+	l.mtx.Unlock()
+	return value, true
+}
+
+// SetClock sets the clock of watching, so it can be tested by a fake clock.
+// It should be called before SetWatch.
+func (l *Loader) SetClock(clk clock.Clock) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.clock = clk
+}
+
+// SetWatch checks files loaded every interval, by their sizes and modification
+// times, and calls onChange with keys of files changed or removed, so they can
+// be invalidated, see ProxyCache.Invalidate. A key is reported once, and
+// watched again after loaded again.
+// Files are polled rather than watched by file system notifications, so
+// changes are reported up to interval late, and each check stats all files
+// watched.
+// Files are watched until reported or forgotten, so Forget should be called
+// on evictions, see ProxyCache.SetOnEvict.
+// If interval is 0, watching is stopped.
+func (l *Loader) SetWatch(interval time.Duration, onChange func(key string)) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.stopWatch != nil {
+		close(l.stopWatch)
+		l.stopWatch = nil
+	}
+	l.files = make(map[string]fileStat)
+	l.onChange = onChange
+	if interval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	l.stopWatch = stop
+	t := l.clock.NewTimer(interval)
+	go func() {
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C():
+				l.check()
+				t.Reset(interval)
+			}
+		}
+	}()
+}
+
+// Forget stops watching the file of key.
+func (l *Loader) Forget(key string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	delete(l.files, key)
+}
+
+// check reports files changed since loaded. Files are stated without Loader
+// locked, so loading is not blocked by a slow file system.
+func (l *Loader) check() {
+	l.mtx.Lock()
+	files := make(map[string]fileStat, len(l.files))
+	for key, s := range l.files {
+		files[key] = s
+	}
+	l.mtx.Unlock()
+
+	var changed []string
+	for key, s := range files {
+		p, ok := l.Path(key)
+		if ok {
+			if fi, err := os.Stat(p); err == nil && statOf(fi).equal(s) {
+				continue
+			}
+		}
+		changed = append(changed, key)
+	}
+
+	l.mtx.Lock()
+	onChange := l.onChange
+	var reported []string
+	for _, key := range changed {
+		// skip keys loaded again during checking.
+		if s, ok := l.files[key]; ok && s.equal(files[key]) {
+			delete(l.files, key)
+			reported = append(reported, key)
+		}
+	}
+	l.mtx.Unlock()
+
+	if onChange != nil {
+		for _, key := range reported {
+			onChange(key)
+		}
+	}
+}
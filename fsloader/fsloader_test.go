@@ -0,0 +1,109 @@
+package fsloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/huangml/proxycache/clock"
+)
+
+func write(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	write(t, filepath.Join(root, "a"), "a")
+	write(t, filepath.Join(root, "sub", "b"), "b")
+	write(t, filepath.Join(dir, "outside"), "outside")
+	if err := os.Symlink(filepath.Join(root, "a"), filepath.Join(root, "in")); err != nil {
+		t.Skip("symbolic links unsupported:", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "outside"), filepath.Join(root, "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key  string
+		want string
+		ok   bool
+	}{
+		{"a", "a", true},
+		{"sub/b", "b", true},
+		{"in", "a", true},
+		{"missing", "", false},
+		{"sub", "", false},
+		{"out", "", false},
+		{"../outside", "", false},
+		{"/a", "", false},
+		{"./a", "", false},
+		{"sub/../a", "", false},
+		{"", "", false},
+	}
+
+	l := New(root)
+	for _, tt := range tests {
+		v, ok := l.Load(tt.key)
+		if string(v) != tt.want || ok != tt.ok {
+			t.Errorf("Load(%q) = %q, %v, want %q, %v", tt.key, v, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	root := t.TempDir()
+	write(t, filepath.Join(root, "a"), "a")
+	write(t, filepath.Join(root, "b"), "b")
+	write(t, filepath.Join(root, "c"), "c")
+
+	clk := clock.NewFake(time.Unix(0, 0))
+	l := New(root)
+	l.SetClock(clk)
+	changed := make(chan string, 10)
+	l.SetWatch(time.Second, func(key string) { changed <- key })
+	defer l.SetWatch(0, nil)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, ok := l.Load(key); !ok {
+			t.Fatalf("Load(%q) failed", key)
+		}
+	}
+	l.Forget("c")
+	write(t, filepath.Join(root, "a"), "changed")
+	write(t, filepath.Join(root, "c"), "changed")
+	if err := os.Remove(filepath.Join(root, "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]bool)
+	for start := time.Now(); len(got) < 2; {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("changed %v, want a and b", got)
+		}
+		clk.Advance(time.Second)
+		select {
+		case key := <-changed:
+			got[key] = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if !got["a"] || !got["b"] {
+		t.Errorf("changed %v, want a and b", got)
+	}
+	clk.Advance(time.Second)
+	select {
+	case key := <-changed:
+		t.Errorf("%q reported after forgotten or reported", key)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
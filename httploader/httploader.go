@@ -0,0 +1,179 @@
+// package httploader implements a ProxyLoader loading values by HTTP GET, with
+// conditional requests revalidating values loaded before.
+package httploader
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/huangml/proxycache/lru"
+)
+
+// DefaultMaxValidators is the default number of keys whose validators are
+// kept, see SetMaxValidators.
+const DefaultMaxValidators = 10000
+
+// Loader loads the value of a key by GET base + key, key escaped as a path.
+// Responses of status 200 are values, 404 means the key doesn't exist, other
+// statuses fail the load.
+// It keeps the ETag and Last-Modified of values loaded, and loads the keys
+// again with If-None-Match and If-Modified-Since. A 304 response returns the
+// value kept, so refreshing unchanged values, by proxycache's soft TTL or by
+// loading expired keys, costs no download, and only extends their TTL.
+type Loader struct {
+	base   string
+	client *http.Client
+
+	mtx           sync.Mutex
+	validators    map[string]*validator
+	use           *lru.LRU
+	maxValidators int
+	downloads     uint64
+	revalidations uint64
+}
+
+type validator struct {
+	etag         string
+	lastModified string
+	value        []byte
+}
+
+// New creates a Loader of base URL. If client is nil, http.DefaultClient is
+// used.
+func New(base string, client *http.Client) *Loader {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Loader{
+		base:          base,
+		client:        client,
+		validators:    make(map[string]*validator),
+		use:           lru.New(),
+		maxValidators: DefaultMaxValidators,
+	}
+}
+
+// SetMaxValidators sets the number of keys whose validators and values are
+// kept for revalidation, least-recently-loaded ones are dropped first.
+// Values kept are shared with the cache, but they are kept after evicted from
+// it, so Forget should be called on evictions, see ProxyCache.SetOnEvict.
+// If maxValidators is 0, there is no limit.
+func (l *Loader) SetMaxValidators(maxValidators int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.maxValidators = maxValidators
+	l.checkMaxWithLock()
+}
+
+func (l *Loader) checkMaxWithLock() {
+	for l.maxValidators > 0 && l.use.Len() > l.maxValidators {
+		delete(l.validators, l.use.Pop().(string))
+	}
+}
+
+// Forget drops the validators of key, so the next load downloads it fully.
+func (l *Loader) Forget(key string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.forgetWithLock(key)
+}
+
+func (l *Loader) forgetWithLock(key string) {
+	delete(l.validators, key)
+	l.use.Remove(key)
+}
+
+// Load loads the value of key.
+func (l *Loader) Load(key string) ([]byte, bool) {
+	return l.LoadContext(context.Background(), key)
+}
+
+// LoadContext loads the value of key, the request is canceled with ctx.
+func (l *Loader) LoadContext(ctx context.Context, key string) ([]byte, bool) {
+	req, err := http.NewRequest("GET", l.base+url.PathEscape(key), nil)
+	if err != nil {
+		return nil, false
+	}
+	req = req.WithContext(ctx)
+
+	l.mtx.Lock()
+	v := l.validators[key]
+	l.mtx.Unlock()
+	if v != nil {
+		if v.etag != "" {
+			req.Header.Set("If-None-Match", v.etag)
+		}
+		if v.lastModified != "" {
+			req.Header.Set("If-Modified-Since", v.lastModified)
+		}
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, false
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && v != nil:
+		l.mtx.Lock()
+		l.revalidations++
+		if l.validators[key] == v {
+			l.use.Touch(key)
+		}
+		l.mtx.Unlock()
+		return v.value, true
+	case resp.StatusCode == http.StatusNotFound:
+		l.Forget(key)
+		return nil, false
+	case resp.StatusCode != http.StatusOK:
+		return nil, false
+	}
+
+	value, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.downloads++
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		l.forgetWithLock(key)
+		return value, true
+	}
+	l.validators[key] = &validator{
+		etag:         etag,
+		lastModified: lastModified,
+		value:        value,
+	}
+	l.use.Touch(key)
+	l.checkMaxWithLock()
+	return value, true
+}
+
+// Stats is the statistics of Loader.
+type Stats struct {
+	Validators    int    `json:"validators"`    // keys kept for revalidation
+	Downloads     uint64 `json:"downloads"`     // loads downloaded values
+	Revalidations uint64 `json:"revalidations"` // loads answered by 304
+}
+
+// Stats returns the statistics of Loader.
+func (l *Loader) Stats() Stats {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return Stats{
+		Validators:    len(l.validators),
+		Downloads:     l.downloads,
+		Revalidations: l.revalidations,
+	}
+}
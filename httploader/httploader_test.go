@@ -0,0 +1,103 @@
+package httploader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type server struct {
+	mtx    sync.Mutex
+	values map[string]string // by path
+	etag   string
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	v, ok := s.values[r.URL.EscapedPath()]
+	switch {
+	case !ok:
+		http.NotFound(w, r)
+	case v == "fail":
+		w.WriteHeader(http.StatusInternalServerError)
+	case s.etag != "" && r.Header.Get("If-None-Match") == s.etag:
+		w.WriteHeader(http.StatusNotModified)
+	default:
+		if s.etag != "" {
+			w.Header().Set("ETag", s.etag)
+		}
+		w.Write([]byte(v))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	s := &server{values: map[string]string{
+		"/a":     "a",
+		"/a%2Fb": "a/b",
+		"/x":     "fail",
+	}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	tests := []struct {
+		key  string
+		want string
+		ok   bool
+	}{
+		{"a", "a", true},
+		{"a/b", "a/b", true},
+		{"missing", "", false},
+		{"x", "", false},
+	}
+
+	l := New(ts.URL+"/", nil)
+	for _, tt := range tests {
+		v, ok := l.Load(tt.key)
+		if string(v) != tt.want || ok != tt.ok {
+			t.Errorf("Load(%q) = %q, %v, want %q, %v", tt.key, v, ok, tt.want, tt.ok)
+		}
+	}
+	if st := l.Stats(); st.Validators != 0 || st.Downloads != 2 {
+		t.Errorf("Stats() = %+v, want 2 downloads without validators", st)
+	}
+}
+
+func TestRevalidate(t *testing.T) {
+	s := &server{values: map[string]string{"/a": "v1", "/b": "b"}, etag: `"1"`}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	l := New(ts.URL+"/", nil)
+	load := func(key, want string) {
+		t.Helper()
+		if v, ok := l.Load(key); !ok || string(v) != want {
+			t.Fatalf("Load(%q) = %q, %v, want %q", key, v, ok, want)
+		}
+	}
+
+	load("a", "v1")
+	load("a", "v1")
+	if st := l.Stats(); st.Downloads != 1 || st.Revalidations != 1 || st.Validators != 1 {
+		t.Fatalf("Stats() = %+v, want 1 download and 1 revalidation", st)
+	}
+
+	s.mtx.Lock()
+	s.values["/a"], s.etag = "v2", `"2"`
+	s.mtx.Unlock()
+	load("a", "v2")
+
+	// validators over the limit are dropped least-recently-loaded first.
+	l.SetMaxValidators(1)
+	load("b", "b")
+	load("a", "v2")
+	if st := l.Stats(); st.Downloads != 4 || st.Validators != 1 {
+		t.Errorf("Stats() = %+v, want 4 downloads and 1 validator", st)
+	}
+	l.Forget("a")
+	if st := l.Stats(); st.Validators != 0 {
+		t.Errorf("Stats() = %+v after forgotten, want no validators", st)
+	}
+}
@@ -0,0 +1,66 @@
+package proxycache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/huangml/proxycache/bus"
+	"github.com/huangml/proxycache/cache"
+)
+
+// SetBus sets the Bus to keep ProxyCache instances consistent.
+// Once data is saved, or invalidated by Invalidate, other instances on the
+// same bus drop their copies, and will load it again on the next Get.
+// Data not saved of other instances are kept.
+func (p *ProxyCache) SetBus(b bus.Bus) error {
+	var id [8]byte
+	rand.Read(id[:])
+	origin := hex.EncodeToString(id[:])
+
+	cancel, err := b.Subscribe(func(m bus.Message) {
+		if m.Origin != origin {
+			p.invalidate(m.Key)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	p.busMtx.Lock()
+	defer p.busMtx.Unlock()
+
+	if p.unsubscribe != nil {
+		p.unsubscribe()
+	}
+	p.bus, p.origin, p.unsubscribe = b, origin, cancel
+
+	p.saver.SetOnSave(func(entry *cache.Entry, ok bool) {
+		if ok {
+			p.publish(entry.Key)
+		}
+	})
+	return nil
+}
+
+// Invalidate drops data from cache, and tells other instances on the Bus to
+// drop their copies, see SetBus. Data not saved are kept.
+func (p *ProxyCache) Invalidate(key string) error {
+	p.invalidate(key)
+	return p.publish(key)
+}
+
+func (p *ProxyCache) invalidate(key string) {
+	p.loader.Forget(key)
+	p.cache.Delete(key)
+}
+
+func (p *ProxyCache) publish(key string) error {
+	p.busMtx.Lock()
+	b, origin := p.bus, p.origin
+	p.busMtx.Unlock()
+
+	if b == nil {
+		return nil
+	}
+	return b.Publish(bus.Message{Origin: origin, Key: key})
+}
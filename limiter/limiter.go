@@ -0,0 +1,143 @@
+// package limiter limits the number of goroutines doing some work
+// concurrently.
+package limiter
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// Limiter hands out a limited number of slots. Goroutines waiting for slots
+// are served in the order they arrive.
+type Limiter struct {
+	mtx     sync.Mutex
+	max     int
+	used    int
+	waiters *list.List // of chan struct{}, closed when granted
+}
+
+// New creates a Limiter of max slots.
+func New(max int) *Limiter {
+	l := &Limiter{waiters: list.New()}
+	l.SetMax(max)
+	return l
+}
+
+// SetMax sets the number of slots. Slots in use beyond max are taken back as
+// they are released. If max is 0, no slots are handed out.
+func (l *Limiter) SetMax(max int) {
+	if max < 0 {
+		max = 0
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.max = max
+	l.grantWithLock()
+}
+
+// Acquire blocks until a slot is available.
+func (l *Limiter) Acquire() {
+	if ch := l.wait(); ch != nil {
+		<-ch
+	}
+}
+
+// TryAcquire acquires a slot only if it is available now.
+// It returns false if no slot is available.
+func (l *Limiter) TryAcquire() bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.used < l.max && l.waiters.Len() == 0 {
+		l.used++
+		return true
+	}
+	return false
+}
+
+// AcquireContext blocks until a slot is available, or ctx is done, in which
+// case ctx.Err() is returned and no slot is acquired.
+func (l *Limiter) AcquireContext(ctx context.Context) error {
+	ch := l.wait()
+	if ch == nil {
+		return nil
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	select {
+	case <-ch:
+		// granted meanwhile, give it back
+		l.used--
+		l.grantWithLock()
+	default:
+		for e := l.waiters.Front(); e != nil; e = e.Next() {
+			if e.Value.(chan struct{}) == ch {
+				l.waiters.Remove(e)
+				break
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// wait acquires a slot if available and returns nil, otherwise returns a
+// channel closed when a slot is granted.
+func (l *Limiter) wait() chan struct{} {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.used < l.max && l.waiters.Len() == 0 {
+		l.used++
+		return nil
+	}
+	ch := make(chan struct{})
+	l.waiters.PushBack(ch)
+	return ch
+}
+
+// Release releases a slot acquired.
+func (l *Limiter) Release() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.used--
+	l.grantWithLock()
+}
+
+func (l *Limiter) grantWithLock() {
+	for l.used < l.max && l.waiters.Len() > 0 {
+		ch := l.waiters.Remove(l.waiters.Front()).(chan struct{})
+		l.used++
+		close(ch)
+	}
+}
+
+// Stats is the state of a Limiter.
+type Stats struct {
+	Max     int `json:"max"`
+	InUse   int `json:"inUse"`
+	Waiting int `json:"waiting"`
+}
+
+// Stats returns the state of the Limiter.
+func (l *Limiter) Stats() Stats {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return Stats{
+		Max:     l.max,
+		InUse:   l.used,
+		Waiting: l.waiters.Len(),
+	}
+}
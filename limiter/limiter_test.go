@@ -0,0 +1,119 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTryAcquire(t *testing.T) {
+	tests := []struct {
+		max      int
+		acquired int
+	}{
+		{0, 0},
+		{1, 1},
+		{3, 3},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.max)
+		n := 0
+		for i := 0; i < tt.max+2; i++ {
+			if l.TryAcquire() {
+				n++
+			}
+		}
+		if n != tt.acquired {
+			t.Errorf("New(%d): %d slots acquired, want %d", tt.max, n, tt.acquired)
+		}
+		if s := l.Stats(); s.InUse != tt.acquired || s.Max != tt.max {
+			t.Errorf("New(%d): Stats() = %+v", tt.max, s)
+		}
+	}
+}
+
+func TestAcquireInOrder(t *testing.T) {
+	l := New(1)
+	l.Acquire()
+
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			l.Acquire()
+			order <- i
+			l.Release()
+		}(i)
+		waitFor(t, func() bool { return l.Stats().Waiting == i+1 })
+	}
+
+	l.Release()
+	for i := 0; i < 3; i++ {
+		if got := <-order; got != i {
+			t.Fatalf("waiter %d served as %d-th", got, i)
+		}
+	}
+	if s := l.Stats(); s.InUse != 0 || s.Waiting != 0 {
+		t.Errorf("Stats() = %+v after all released", s)
+	}
+}
+
+func TestAcquireContext(t *testing.T) {
+	l := New(1)
+	if err := l.AcquireContext(context.Background()); err != nil {
+		t.Fatalf("AcquireContext() = %v with a free slot", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- l.AcquireContext(ctx) }()
+	waitFor(t, func() bool { return l.Stats().Waiting == 1 })
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("AcquireContext() = %v after canceled, want %v", err, context.Canceled)
+	}
+
+	// the canceled waiter takes no slot.
+	l.Release()
+	if s := l.Stats(); s.InUse != 0 || s.Waiting != 0 {
+		t.Errorf("Stats() = %+v, want no slots in use or waiting", s)
+	}
+	if !l.TryAcquire() {
+		t.Error("TryAcquire() failed after the canceled waiter left")
+	}
+}
+
+func TestSetMax(t *testing.T) {
+	l := New(2)
+	l.Acquire()
+	l.Acquire()
+
+	l.SetMax(1)
+	l.Release()
+	if l.TryAcquire() {
+		t.Fatal("TryAcquire() succeeded with slots over the new max in use")
+	}
+	l.Release()
+	if !l.TryAcquire() {
+		t.Fatal("TryAcquire() failed after slots are taken back")
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		l.Acquire()
+		close(acquired)
+	}()
+	waitFor(t, func() bool { return l.Stats().Waiting == 1 })
+	l.SetMax(2)
+	<-acquired
+}
+
+// waitFor waits until f returns true, failing t after a while.
+func waitFor(t *testing.T, f func() bool) {
+	t.Helper()
+	for start := time.Now(); !f(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timed out")
+		}
+	}
+}
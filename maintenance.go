@@ -0,0 +1,31 @@
+package proxycache
+
+// SetMaintenance switches maintenance mode, for backend maintenance windows.
+// In maintenance mode, ProxyCache serves only data it has, without loading
+// from or saving to database. Data not cached is not found, and writing
+// methods return ErrMaintenance. Pending saves are held, and saved after
+// maintenance mode is switched off.
+// If serveExpired is true, expired data kept for serving stale is also
+// served, see SetServeStale.
+func (p *ProxyCache) SetMaintenance(enabled, serveExpired bool) {
+	p.maintMtx.Lock()
+	defer p.maintMtx.Unlock()
+
+	p.maintenance = enabled
+	p.serveExpired = serveExpired
+	p.buffer.SetPaused(enabled)
+}
+
+func (p *ProxyCache) inMaintenance() (enabled, serveExpired bool) {
+	p.maintMtx.Lock()
+	defer p.maintMtx.Unlock()
+
+	return p.maintenance, p.serveExpired
+}
+
+// MaintenanceStatus tells whether ProxyCache is in maintenance mode, see
+// SetMaintenance.
+type MaintenanceStatus struct {
+	Maintenance             bool `json:"maintenance"`
+	MaintenanceServeExpired bool `json:"maintenanceServeExpired"`
+}
@@ -0,0 +1,193 @@
+package proxycache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// maxMemcacheKey is the maximum length of keys in memcached protocol.
+const maxMemcacheKey = 250
+
+// DefaultMemcacheMaxItem is the default maximum size of values set by
+// memcached protocol, see SetMemcacheMaxItem.
+const DefaultMemcacheMaxItem = 1 << 20
+
+// SetMemcacheMaxItem sets the maximum size of values set by ServeMemcache,
+// larger ones are rejected. If size is 0, DefaultMemcacheMaxItem is used.
+func (p *ProxyCache) SetMemcacheMaxItem(size int) {
+	if size <= 0 {
+		size = DefaultMemcacheMaxItem
+	}
+
+	p.serveMtx.Lock()
+	defer p.serveMtx.Unlock()
+
+	p.memcacheMaxItem = size
+}
+
+func (p *ProxyCache) memcacheMaxItemSize() int {
+	p.serveMtx.Lock()
+	defer p.serveMtx.Unlock()
+
+	if p.memcacheMaxItem == 0 {
+		return DefaultMemcacheMaxItem
+	}
+	return p.memcacheMaxItem
+}
+
+// ServeMemcache serves the memcached text protocol on l, so clients of
+// memcached can use ProxyCache as a read-through cache. It blocks until l
+// fails, and returns the error.
+// Supported commands are get, gets, set, delete, stats, version and quit.
+// Keys missing in cache are loaded by get from database, multiple keys are
+// loaded together like GetMulti. set and delete save to database with ttw.
+// Flags and exptime of set are ignored, values are always returned with flags
+// 0, and expire by the TTL of Cache. The cas unique returned by gets is the
+// version of the entry.
+func (p *ProxyCache) ServeMemcache(l net.Listener, ttw int64) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.serveMemcacheConn(conn, ttw)
+	}
+}
+
+func (p *ProxyCache) serveMemcacheConn(conn net.Conn, ttw int64) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			w.WriteString("ERROR\r\n")
+		} else if args[0] == "quit" {
+			return
+		} else if err := p.execMemcache(r, w, args, ttw); err != nil {
+			return
+		}
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+var errMemcacheTooLarge = errors.New("proxycache: memcache value too large")
+
+// noreply tells whether the optional argument i is absent, or "noreply".
+func noreply(args []string, i int) bool {
+	return len(args) <= i || args[i] == "noreply"
+}
+
+// execMemcache executes a command. It returns an error only if the connection
+// should be closed.
+func (p *ProxyCache) execMemcache(r *bufio.Reader, w *bufio.Writer, args []string, ttw int64) error {
+	switch args[0] {
+	case "get", "gets":
+		if len(args) < 2 {
+			w.WriteString("ERROR\r\n")
+			return nil
+		}
+		keys := args[1:]
+		for _, key := range keys {
+			if len(key) > maxMemcacheKey {
+				w.WriteString("CLIENT_ERROR bad command line format\r\n")
+				return nil
+			}
+		}
+		for i, value := range p.GetMulti(keys) {
+			if value == nil {
+				continue
+			}
+			if args[0] == "gets" {
+				m, _ := p.Meta(keys[i])
+				fmt.Fprintf(w, "VALUE %s 0 %d %d\r\n", keys[i], len(value), m.Version)
+			} else {
+				fmt.Fprintf(w, "VALUE %s 0 %d\r\n", keys[i], len(value))
+			}
+			w.Write(value)
+			w.WriteString("\r\n")
+		}
+		w.WriteString("END\r\n")
+	case "set":
+		// set <key> <flags> <exptime> <bytes> [noreply]
+		if len(args) != 5 && len(args) != 6 {
+			w.WriteString("ERROR\r\n")
+			return nil
+		}
+		n, err := strconv.ParseInt(args[4], 10, 64)
+		if err != nil || n < 0 || len(args[1]) > maxMemcacheKey || !noreply(args, 5) {
+			w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return nil
+		}
+		if n > int64(p.memcacheMaxItemSize()) {
+			// swallow the data, so the connection keeps in sync.
+			if n > math.MaxInt64-2 {
+				return errMemcacheTooLarge
+			}
+			if _, err := io.CopyN(ioutil.Discard, r, n+2); err != nil {
+				return err
+			}
+			w.WriteString("SERVER_ERROR object too large for cache\r\n")
+			return nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		if string(data[n:]) != "\r\n" {
+			w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+			return nil
+		}
+		reply := "STORED\r\n"
+		if err := p.Put(args[1], data[:n], ttw); err != nil {
+			reply = "SERVER_ERROR " + err.Error() + "\r\n"
+		}
+		if len(args) == 5 {
+			w.WriteString(reply)
+		}
+	case "delete":
+		// delete <key> [noreply]
+		if len(args) != 2 && len(args) != 3 {
+			w.WriteString("ERROR\r\n")
+			return nil
+		}
+		if !noreply(args, 2) {
+			w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return nil
+		}
+		reply := "DELETED\r\n"
+		if _, cached := p.Meta(args[1]); !cached {
+			reply = "NOT_FOUND\r\n"
+		}
+		if err := p.Delete(args[1], ttw); err != nil {
+			reply = "SERVER_ERROR " + err.Error() + "\r\n"
+		}
+		if len(args) == 2 {
+			w.WriteString(reply)
+		}
+	case "stats":
+		for _, f := range p.statusFields() {
+			fmt.Fprintf(w, "STAT %s %s\r\n", f.name, f.value)
+		}
+		w.WriteString("END\r\n")
+	case "version":
+		w.WriteString("VERSION proxycache\r\n")
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+	return nil
+}
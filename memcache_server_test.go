@@ -0,0 +1,78 @@
+package proxycache
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+// memcache runs the commands of input on a connection of p, and returns the
+// replies until the connection is closed.
+func memcache(t *testing.T, p *ProxyCache, input string) string {
+	t.Helper()
+	client, server := net.Pipe()
+	go p.serveMemcacheConn(server, 0)
+	go client.Write([]byte(input + "quit\r\n"))
+
+	out, err := ioutil.ReadAll(client)
+	client.Close()
+	if err != nil {
+		t.Fatalf("reading replies: %v", err)
+	}
+	return string(out)
+}
+
+func TestMemcache(t *testing.T) {
+	long := strings.Repeat("k", maxMemcacheKey+1)
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"get missing", "get x\r\n", "END\r\n"},
+		{"get loaded", "get db\r\n", "VALUE db 0 2\r\nDB\r\nEND\r\n"},
+		{"get multi", "get x db\r\n", "VALUE db 0 2\r\nDB\r\nEND\r\n"},
+		{"gets", "set a 0 0 1\r\nx\r\ngets a\r\n", "STORED\r\nVALUE a 0 1 1\r\nx\r\nEND\r\n"},
+		{"set", "set a 1 60 3\r\na\r\n\r\nget a\r\n", "STORED\r\nVALUE a 0 3\r\na\r\n\r\nEND\r\n"},
+		{"set empty", "set a 0 0 0\r\n\r\nget a\r\n", "STORED\r\nVALUE a 0 0\r\n\r\nEND\r\n"},
+		{"set noreply", "set a 0 0 1 noreply\r\nx\r\nget a\r\n", "VALUE a 0 1\r\nx\r\nEND\r\n"},
+		{"set bad noreply", "set a 0 0 1 reply\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"set bad length", "set a 0 0 -1\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"set too few args", "set a 0 0\r\n", "ERROR\r\n"},
+		{"set bad chunk", "set a 0 0 1\r\nxy\r\nget a\r\n", "CLIENT_ERROR bad data chunk\r\nERROR\r\nEND\r\n"},
+		{"set too large", "set a 0 0 5\r\nhello\r\nget a\r\n", "SERVER_ERROR object too large for cache\r\nEND\r\n"},
+		{"set long key", "set " + long + " 0 0 1\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"get long key", "get a " + long + "\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"get no key", "get\r\n", "ERROR\r\n"},
+		{"delete", "set a 0 0 1\r\nx\r\ndelete a\r\nget a\r\n", "STORED\r\nDELETED\r\nEND\r\n"},
+		{"delete missing", "delete x\r\n", "NOT_FOUND\r\n"},
+		{"delete noreply", "set a 0 0 1\r\nx\r\ndelete a noreply\r\nget a\r\n", "STORED\r\nEND\r\n"},
+		{"delete bad noreply", "delete a now\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"version", "version\r\n", "VERSION proxycache\r\n"},
+		{"empty line", "\r\n", "ERROR\r\n"},
+		{"unknown", "flush_all\r\n", "ERROR\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, l := newTestProxyCache(t)
+			p.SetMemcacheMaxItem(4)
+			l.Set("db", []byte("DB"))
+
+			if got := memcache(t, p, tt.input); got != tt.want {
+				t.Errorf("replies to %q = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemcacheTooLarge(t *testing.T) {
+	p, _ := newTestProxyCache(t)
+
+	// the connection is closed, rather than swallowing the data.
+	if got := memcache(t, p, "set a 0 0 9223372036854775807\r\n"); got != "" {
+		t.Errorf("replies = %q, want none", got)
+	}
+}
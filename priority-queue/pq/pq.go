@@ -18,7 +18,7 @@ func New() *PriorityQueue {
 
 // Priority retrieves values's priority.
 func (p *PriorityQueue) Priority(value interface{}) (priority int64, ok bool) {
-	if e, ok := p.index[value]; ok {
+	if e, found := p.index[value]; found {
 		priority, ok = e.priority, true
 	}
 	return
@@ -49,6 +49,15 @@ func (p *PriorityQueue) Pop() interface{} {
 	}
 }
 
+// Peek returns value with minimal priority without popping it. It returns nil
+// if no value exists.
+func (p *PriorityQueue) Peek() (value interface{}, priority int64) {
+	if len(p.heap) == 0 {
+		return nil, 0
+	}
+	return p.heap[0].value, p.heap[0].priority
+}
+
 // Remove removes the provided value from queue.
 func (p *PriorityQueue) Remove(value interface{}) {
 	if e, ok := p.index[value]; ok {
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/huangml/proxycache/clock"
+)
+
+// bandwidth is a token bucket of bytes loaded.
+// Sizes of values are unknown before loading, so loads are charged after they
+// finish, and new loads wait while the bucket is in debt.
+type bandwidth struct {
+	mtx       sync.Mutex
+	rate      float64 // bytes per second
+	burst     float64
+	tokens    float64
+	last      time.Time
+	clock     clock.Clock
+	bytes     uint64
+	throttled uint64 // loads waited for bandwidth
+}
+
+func newBandwidth(rate, burst int, clk clock.Clock) *bandwidth {
+	if burst < rate {
+		burst = rate
+	}
+	return &bandwidth{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clk.Now(),
+		clock:  clk,
+	}
+}
+
+func (b *bandwidth) refillWithLock() {
+	now := b.clock.Now()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// wait blocks until the bucket is out of debt, or returns ctx.Err() if ctx is
+// done first.
+func (b *bandwidth) wait(ctx context.Context) error {
+	counted := false
+	for {
+		b.mtx.Lock()
+		b.refillWithLock()
+		if b.tokens >= 0 {
+			b.mtx.Unlock()
+			return nil
+		}
+		if !counted {
+			b.throttled++
+			counted = true
+		}
+		d := time.Duration(-b.tokens / b.rate * float64(time.Second))
+		b.mtx.Unlock()
+
+		select {
+		case <-b.clock.After(d + time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// charge takes n bytes loaded from the bucket.
+func (b *bandwidth) charge(n int) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.refillWithLock()
+	b.tokens -= float64(n)
+	b.bytes += uint64(n)
+}
+
+// SetBandwidth limits the bytes per second of values returned by ProxyLoader,
+// allowing bursts of up to burst bytes. Loads wait, before taking goroutines
+// of maxProc, while values loaded earlier are over the limit. A single value
+// larger than burst is still loaded, and delays later loads accordingly.
+// If bytesPerSec is 0, there is no limit.
+// It should be called after SetClock.
+func (l *Loader) SetBandwidth(bytesPerSec, burst int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.bandwidth = nil
+	if bytesPerSec > 0 {
+		l.bandwidth = newBandwidth(bytesPerSec, burst, l.clock)
+	}
+}
@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"context"
+	"runtime/pprof"
+	"sync"
+)
+
+// BatchProxyLoader is the interface wraps the LoadBatch method.
+// A ProxyLoader implements it is able to load multiple keys in one call, see
+// Loader.LoadMulti.
+type BatchProxyLoader interface {
+	ProxyLoader
+	LoadBatch(keys []string) (values [][]byte, ok []bool)
+}
+
+// SetPartitioner sets the function telling which backend shard a key belongs
+// to, so LoadMulti issues one batch per shard. If f is nil, all keys are in
+// the same shard.
+// f is called with Loader locked, so it must not call Loader's methods.
+func (l *Loader) SetPartitioner(f func(key string) int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.partition = f
+}
+
+// SetMaxLoadBatch sets the maximum number of keys loaded in one batch, larger
+// shards are split into several batches. If maxBatch is 0, there is no limit.
+func (l *Loader) SetMaxLoadBatch(maxBatch int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.maxLoadBatch = maxBatch
+}
+
+// LoadMulti loads multiple keys like Load, it returns values and oks in the
+// order of keys.
+func (l *Loader) LoadMulti(keys []string) ([][]byte, []bool) {
+	return l.LoadMultiAndFill(keys, nil)
+}
+
+// LoadMultiAndFill loads multiple keys like LoadAndFill.
+// If ProxyLoader is a BatchProxyLoader, keys not in-flight are grouped by
+// shards, see SetPartitioner, and loaded by one batch per shard concurrently,
+// each batch taking one goroutine of maxProc. Otherwise keys are loaded one by
+// one concurrently. Batches are not limited by tenants, see SetTenantLimit.
+// fill is called for each key like LoadAndFill.
+func (l *Loader) LoadMultiAndFill(keys []string, fill func(key string, value []byte, ok bool)) ([][]byte, []bool) {
+	values := make([][]byte, len(keys))
+	oks := make([]bool, len(keys))
+
+	bp, ok := l.p.(BatchProxyLoader)
+	if !ok {
+		var wg sync.WaitGroup
+		for i, key := range keys {
+			wg.Add(1)
+			go func(i int, key string) {
+				defer wg.Done()
+				var f func(value []byte, ok bool)
+				if fill != nil {
+					f = func(value []byte, ok bool) { fill(key, value, ok) }
+				}
+				values[i], oks[i] = l.LoadAndFill(key, f)
+			}(i, key)
+		}
+		wg.Wait()
+		return values, oks
+	}
+
+	results := make(map[string]*loadResult, len(keys))
+	var batches [][]string
+	var joined []*loadResult
+
+	l.mtx.Lock()
+	missing := l.missing
+	shards := make(map[int][]string)
+	var order []int
+	for _, key := range keys {
+		if _, ok := results[key]; ok {
+			continue
+		}
+		if missing != nil && missing.test(key) {
+			f := &loadResult{done: make(chan struct{})}
+			close(f.done)
+			results[key] = f
+			continue
+		}
+		if f, ok := l.inFlight[key]; ok {
+			f.waiters++
+			l.joined++
+			joined = append(joined, f)
+			results[key] = f
+			continue
+		}
+
+		f := &loadResult{done: make(chan struct{}), waiters: 1, cancel: func() {}}
+		l.inFlight[key] = f
+		results[key] = f
+
+		shard := 0
+		if l.partition != nil {
+			shard = l.partition(key)
+		}
+		if _, ok := shards[shard]; !ok {
+			order = append(order, shard)
+		}
+		shards[shard] = append(shards[shard], key)
+	}
+	for _, shard := range order {
+		batch := shards[shard]
+		for l.maxLoadBatch > 0 && len(batch) > l.maxLoadBatch {
+			batches = append(batches, batch[:l.maxLoadBatch])
+			batch = batch[l.maxLoadBatch:]
+		}
+		batches = append(batches, batch)
+	}
+	bw := l.bandwidth
+	l.mtx.Unlock()
+
+	var wg sync.WaitGroup
+	for _, batch := range batches {
+		wg.Add(1)
+		go func(batch []string) {
+			defer wg.Done()
+			l.loadBatch(bp, batch, results, missing, bw, fill)
+		}(batch)
+	}
+	wg.Wait()
+
+	for _, f := range joined {
+		<-f.done
+		l.unjoin()
+	}
+	for i, key := range keys {
+		f := results[key]
+		values[i], oks[i] = f.value, f.ok
+	}
+	return values, oks
+}
+
+// loadBatch loads keys by one call, and fills the results.
+func (l *Loader) loadBatch(bp BatchProxyLoader, keys []string, results map[string]*loadResult, missing *missFilter, bw *bandwidth, fill func(key string, value []byte, ok bool)) {
+	if bw != nil {
+		bw.wait(context.Background())
+	}
+	l.limit.Acquire()
+	var values [][]byte
+	var oks []bool
+	if labels := l.labels(keys[0]); labels == nil {
+		values, oks = bp.LoadBatch(keys)
+	} else {
+		pprof.Do(context.Background(), pprof.Labels(labels...), func(context.Context) {
+			values, oks = bp.LoadBatch(keys)
+		})
+	}
+	l.limit.Release()
+	if bw != nil {
+		n := 0
+		for _, value := range values {
+			n += len(value)
+		}
+		bw.charge(n)
+	}
+
+	l.mtx.Lock()
+	for i, key := range keys {
+		f := results[key]
+		if i < len(values) && i < len(oks) {
+			f.value, f.ok = values[i], oks[i]
+		}
+		if !f.ok && !f.written && missing != nil {
+			missing.add(key)
+		}
+	}
+	l.mtx.Unlock()
+
+	for _, key := range keys {
+		f := results[key]
+		if fill != nil {
+			f.fill(func(value []byte, ok bool) { fill(key, value, ok) })
+		}
+		l.finish(key, f)
+	}
+}
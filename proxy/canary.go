@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/huangml/proxycache/clock"
+)
+
+// Canary is a ProxyLoader routes a percentage of loads to a canary
+// ProxyLoader, the rest to the stable one.
+// Keys are split by hash, so a key always goes to the same branch as long as
+// the percentage is unchanged.
+type Canary struct {
+	stable ProxyLoader
+	canary ProxyLoader
+
+	mtx     sync.Mutex
+	percent float64
+	clock   clock.Clock
+	branch  [2]CanaryBranchStatus // stable, canary
+}
+
+// NewCanary creates a Canary, routes percent (0 to 100) of keys to canary.
+func NewCanary(stable, canary ProxyLoader, percent float64) *Canary {
+	c := &Canary{stable: stable, canary: canary, clock: clock.Real}
+	c.SetPercent(percent)
+	return c
+}
+
+// SetPercent changes the percentage of keys routed to canary.
+func (c *Canary) SetPercent(percent float64) {
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.percent = percent
+}
+
+// SetClock sets the clock of load times, so they can be tested by a fake
+// clock.
+func (c *Canary) SetClock(clk clock.Clock) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.clock = clk
+}
+
+// IsCanary tells whether key is routed to canary.
+func (c *Canary) IsCanary(key string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return float64(h.Sum32()%10000) < c.percent*100
+}
+
+// Load loads data from the branch key is routed to.
+func (c *Canary) Load(key string) ([]byte, bool) {
+	return c.LoadContext(context.Background(), key)
+}
+
+// LoadContext loads data like Load, passing ctx to the branch if it is a
+// ContextProxyLoader.
+func (c *Canary) LoadContext(ctx context.Context, key string) ([]byte, bool) {
+	b, p := 0, c.stable
+	if c.IsCanary(key) {
+		b, p = 1, c.canary
+	}
+
+	c.mtx.Lock()
+	clk := c.clock
+	c.mtx.Unlock()
+
+	start := clk.Now()
+	value, ok := LoadContext(ctx, p, key)
+	d := clk.Now().Sub(start)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	s := &c.branch[b]
+	s.Loads++
+	if !ok {
+		s.Misses++
+	}
+	s.LoadTime += d.Nanoseconds() / int64(time.Microsecond)
+	return value, ok
+}
+
+// CanaryBranchStatus is the status of a branch.
+type CanaryBranchStatus struct {
+	Loads    uint64 `json:"loads"`
+	Misses   uint64 `json:"misses"`
+	LoadTime int64  `json:"loadTime"` // total microseconds spent in loading
+}
+
+// CanaryStatus is used for runtime performance profiling.
+type CanaryStatus struct {
+	CanaryPercent float64            `json:"canaryPercent"`
+	Stable        CanaryBranchStatus `json:"stable"`
+	Canary        CanaryBranchStatus `json:"canary"`
+}
+
+// Status returns Canary's runtime performance status.
+func (c *Canary) Status() CanaryStatus {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return CanaryStatus{
+		CanaryPercent: c.percent,
+		Stable:        c.branch[0],
+		Canary:        c.branch[1],
+	}
+}
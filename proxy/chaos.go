@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/huangml/proxycache/clock"
+)
+
+// Faults are the faults injected by Chaos. Rates are probabilities (0 to 1)
+// of each load suffering the fault, independently of others.
+type Faults struct {
+	// FirstByteRate of loads wait FirstByte before calling ProxyLoader, like a
+	// backend slow to respond.
+	FirstByteRate float64
+	FirstByte     time.Duration
+	// LatencyRate of loads wait Latency after ProxyLoader returns, like a slow
+	// transfer.
+	LatencyRate float64
+	Latency     time.Duration
+	// ErrorRate of loads fail without calling ProxyLoader.
+	ErrorRate float64
+	// TruncateRate of values loaded are cut at a random length.
+	TruncateRate float64
+}
+
+// Chaos is a ProxyLoader injects faults into loads of another ProxyLoader, for
+// verifying services degrade gracefully when the backend misbehaves.
+// No faults are injected until SetFaults.
+type Chaos struct {
+	p ProxyLoader
+
+	mtx    sync.Mutex
+	faults Faults
+	clock  clock.Clock
+	status ChaosStatus
+}
+
+// NewChaos creates a Chaos of p.
+func NewChaos(p ProxyLoader) *Chaos {
+	return &Chaos{p: p, clock: clock.Real}
+}
+
+// SetFaults sets the faults injected into loads afterwards. Faults{} stops
+// injecting.
+func (c *Chaos) SetFaults(f Faults) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.faults = f
+}
+
+// SetClock sets the clock of injected delays, so they can be tested by a fake
+// clock.
+func (c *Chaos) SetClock(clk clock.Clock) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.clock = clk
+}
+
+// Load loads data from ProxyLoader, with faults injected.
+func (c *Chaos) Load(key string) ([]byte, bool) {
+	return c.LoadContext(context.Background(), key)
+}
+
+// LoadContext loads data like Load. Injected delays are cut short when ctx is
+// done, and ctx is passed to ProxyLoader if it is a ContextProxyLoader.
+func (c *Chaos) LoadContext(ctx context.Context, key string) ([]byte, bool) {
+	c.mtx.Lock()
+	f, clk := c.faults, c.clock
+	firstByte := hit(f.FirstByteRate)
+	fail := hit(f.ErrorRate)
+	latency := hit(f.LatencyRate)
+	truncate := hit(f.TruncateRate)
+	c.status.ChaosLoads++
+	c.mtx.Unlock()
+
+	if firstByte && !sleep(ctx, clk, f.FirstByte) {
+		return nil, false
+	}
+	if fail {
+		c.count(func(s *ChaosStatus) { s.ChaosErrors++ })
+		return nil, false
+	}
+
+	value, ok := LoadContext(ctx, c.p, key)
+
+	if truncate && ok && len(value) > 0 {
+		value = value[:rand.Intn(len(value))]
+		c.count(func(s *ChaosStatus) { s.ChaosTruncated++ })
+	}
+	if latency && !sleep(ctx, clk, f.Latency) {
+		return nil, false
+	}
+	return value, ok
+}
+
+func (c *Chaos) count(f func(s *ChaosStatus)) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	f(&c.status)
+}
+
+func hit(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// sleep waits d, it returns false if ctx is done first.
+func sleep(ctx context.Context, clk clock.Clock, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	select {
+	case <-clk.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ChaosStatus is used for runtime performance profiling.
+type ChaosStatus struct {
+	ChaosLoads     uint64 `json:"chaosLoads"`
+	ChaosErrors    uint64 `json:"chaosErrors"`
+	ChaosTruncated uint64 `json:"chaosTruncated"`
+}
+
+// Status returns Chaos's runtime performance status.
+func (c *Chaos) Status() ChaosStatus {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.status
+}
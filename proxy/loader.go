@@ -1,19 +1,58 @@
 package proxy
 
-import "sync"
+import (
+	"context"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/huangml/proxycache/clock"
+	"github.com/huangml/proxycache/limiter"
+)
 
 // ProxyLoader is the interface wraps the Load method.
 type ProxyLoader interface {
 	Load(key string) (value []byte, ok bool)
 }
 
+// ContextProxyLoader is the interface wraps the LoadContext method.
+// A ProxyLoader implements it is called by LoadContext instead of Load, with a
+// context canceled when the load is given up, see Loader.SetDetach.
+type ContextProxyLoader interface {
+	LoadContext(ctx context.Context, key string) (value []byte, ok bool)
+}
+
 // Loader provides method to load data by Proxy concurrently.
 type Loader struct {
-	p ProxyLoader
-	*proc
+	p     ProxyLoader
+	limit *limiter.Limiter
 
 	mtx      sync.Mutex
 	inFlight map[string]*loadResult
+	missing  *missFilter
+	clock    clock.Clock
+	onJoin   func(key string)
+	detach   bool
+
+	bandwidth *bandwidth // nil if no limit, see SetBandwidth
+
+	joined    int    // callers waiting for in-flight loads of others
+	abandoned uint64 // loads callers gave up waiting
+
+	// per-tenant limits, see SetTenantLimit.
+	tenantOf          func(key string) string
+	tenants           map[string]*tenant
+	tenantMaxProc     int
+	tenantMaxInflight int
+	rejected          uint64
+
+	// batch loading, see LoadMulti.
+	partition    func(key string) int
+	maxLoadBatch int
+
+	// pprof labels, see SetLabels.
+	name      string
+	namespace func(key string) string
 }
 
 // NewLoader creates a Loader.
@@ -22,51 +61,365 @@ type Loader struct {
 func NewLoader(p ProxyLoader, maxProc int) *Loader {
 	l := &Loader{
 		p:        p,
-		proc:     newProc(maxProc),
+		limit:    limiter.New(clampMaxProc(maxProc)),
 		inFlight: make(map[string]*loadResult),
+		tenants:  make(map[string]*tenant),
+		detach:   true,
+		clock:    clock.Real,
 	}
 
-	go func() {
-		for {
-			<-l.quit
-			<-l.start
-		}
-	}()
-
 	return l
 }
 
 type loadResult struct {
-	done  chan struct{}
-	value []byte
-	ok    bool
+	done    chan struct{}
+	value   []byte
+	ok      bool
+	written bool // written during loading, see ForgetMissing
+	waiters int  // callers waiting, see LoadAndFillContext
+	cancel  func()
+
+	fillMtx   sync.Mutex // held while filling, see forget
+	forgotten bool
+}
+
+// fill passes the result to fill, unless it is forgotten.
+func (f *loadResult) fill(fill func(value []byte, ok bool)) {
+	f.fillMtx.Lock()
+	defer f.fillMtx.Unlock()
+
+	if !f.forgotten && fill != nil {
+		fill(f.value, f.ok)
+	}
+}
+
+// forget marks the result forgotten. It waits for the fill in progress, so the
+// result is never filled after forget returns.
+func (f *loadResult) forget() {
+	f.fillMtx.Lock()
+	defer f.fillMtx.Unlock()
+
+	f.forgotten = true
 }
 
 // Load loads data by the provided key concurrently.
 // Duplicate keys will be loaded only once.
 func (l *Loader) Load(key string) ([]byte, bool) {
+	return l.LoadAndFill(key, nil)
+}
+
+// LoadAndFill loads data like Load, and passes the result to fill unless the
+// key is forgotten during loading.
+// fill is called only once for duplicate keys, by the goroutine does the real
+// loading, before callers waiting for the loading return. Forgetting the key
+// waits for fill in progress, so fill must not forget its own key.
+func (l *Loader) LoadAndFill(key string, fill func(value []byte, ok bool)) ([]byte, bool) {
+	value, ok, _ := l.LoadAndFillContext(context.Background(), key, fill)
+	return value, ok
+}
+
+// LoadAndFillContext loads data like LoadAndFill, and returns ctx.Err() if ctx
+// is done before the loading finishes.
+// The loading is detached from callers, it keeps running after they give up,
+// and fill is still called with the result, so the work is not wasted. If
+// detaching is disabled by SetDetach, the loading is forgotten once all
+// callers waiting for it give up, and canceled if ProxyLoader is a
+// ContextProxyLoader.
+func (l *Loader) LoadAndFillContext(ctx context.Context, key string, fill func(value []byte, ok bool)) ([]byte, bool, error) {
 	l.mtx.Lock()
+	missing := l.missing
+	if missing != nil && missing.test(key) {
+		l.mtx.Unlock()
+		return nil, false, nil
+	}
+
 	if f, ok := l.inFlight[key]; ok {
+		f.waiters++
+		l.joined++
+		onJoin := l.onJoin
 		l.mtx.Unlock()
-		<-f.done
-		return f.value, f.ok
+		if onJoin != nil {
+			onJoin(key)
+		}
+		defer l.unjoin()
+		return l.wait(ctx, key, f)
 	}
 
-	f := &loadResult{done: make(chan struct{})}
+	name, t := l.tenantWithLock(key)
+	var tenantLimit *limiter.Limiter
+	if t != nil {
+		if t.maxInflight > 0 && t.inflight >= t.maxInflight {
+			t.rejected++
+			l.rejected++
+			l.mtx.Unlock()
+			return nil, false, nil
+		}
+		t.inflight++
+		tenantLimit = t.limit
+	}
+
+	loadCtx, cancel := context.WithCancel(context.Background())
+	f := &loadResult{done: make(chan struct{}), waiters: 1, cancel: cancel}
 	l.inFlight[key] = f
+	labels := l.labelsWithLock(key)
+	bw := l.bandwidth
 
 	l.mtx.Unlock()
 
-	<-l.start
-	f.value, f.ok = l.p.Load(key)
-	l.start <- struct{}{}
+	load := func() {
+		l.acquireAndCall(loadCtx, key, f, bw, tenantLimit, labels)
+		canceled := loadCtx.Err() != nil
+		cancel()
+
+		l.mtx.Lock()
+		if t != nil {
+			t.inflight--
+			l.pruneTenantWithLock(name, t)
+		}
+		if !f.ok && !f.written && !canceled && missing != nil {
+			missing.add(key)
+		}
+		l.mtx.Unlock()
+
+		// fill without Loader locked, callers of the key keep joining the
+		// loading until it is filled.
+		f.fill(fill)
+		l.finish(key, f)
+	}
+
+	if ctx.Done() == nil {
+		// never canceled, no need to detach.
+		load()
+		return f.value, f.ok, nil
+	}
+	go load()
+	return l.wait(ctx, key, f)
+}
+
+// acquireAndCall waits for bandwidth and goroutines of the tenant and maxProc,
+// then calls ProxyLoader. It gives up once ctx is canceled, so an abandoned
+// load doesn't hold them, see SetDetach.
+func (l *Loader) acquireAndCall(ctx context.Context, key string, f *loadResult, bw *bandwidth, tenantLimit *limiter.Limiter, labels []string) {
+	if bw != nil && bw.wait(ctx) != nil {
+		return
+	}
+	if tenantLimit != nil {
+		if tenantLimit.AcquireContext(ctx) != nil {
+			return
+		}
+		defer tenantLimit.Release()
+	}
+	if l.limit.AcquireContext(ctx) != nil {
+		return
+	}
+	defer l.limit.Release()
+	if ctx.Err() != nil {
+		return
+	}
+
+	if labels == nil {
+		f.value, f.ok = l.call(ctx, key)
+	} else {
+		pprof.Do(ctx, pprof.Labels(labels...), func(ctx context.Context) {
+			f.value, f.ok = l.call(ctx, key)
+		})
+	}
+	if bw != nil {
+		bw.charge(len(f.value))
+	}
+}
+
+// finish removes an in-flight load filled, and wakes up callers waiting for it.
+func (l *Loader) finish(key string, f *loadResult) {
+	l.mtx.Lock()
+	if l.inFlight[key] == f {
+		delete(l.inFlight, key)
+	}
+	l.mtx.Unlock()
 	close(f.done)
+}
 
+func (l *Loader) unjoin() {
 	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.joined--
+}
+
+// call calls ProxyLoader, preferring ContextProxyLoader.
+func (l *Loader) call(ctx context.Context, key string) ([]byte, bool) {
+	return LoadContext(ctx, l.p, key)
+}
+
+// LoadContext loads key by p.LoadContext if p is a ContextProxyLoader, or by
+// p.Load otherwise. ProxyLoaders wrapping others should load by it, so ctx
+// reaches the ContextProxyLoader wrapped.
+func LoadContext(ctx context.Context, p ProxyLoader, key string) ([]byte, bool) {
+	if cp, ok := p.(ContextProxyLoader); ok {
+		return cp.LoadContext(ctx, key)
+	}
+	return p.Load(key)
+}
+
+// wait waits for an in-flight load, or gives it up when ctx is done.
+func (l *Loader) wait(ctx context.Context, key string, f *loadResult) ([]byte, bool, error) {
+	select {
+	case <-f.done:
+		return f.value, f.ok, nil
+	case <-ctx.Done():
+	}
+
+	l.mtx.Lock()
+	f.waiters--
+	select {
+	case <-f.done:
+		l.mtx.Unlock()
+		return f.value, f.ok, nil
+	default:
+	}
+	l.abandoned++
+	abandon := !l.detach && f.waiters == 0 && l.inFlight[key] == f
+	if abandon {
+		delete(l.inFlight, key)
+	}
+	l.mtx.Unlock()
+
+	if abandon {
+		f.cancel()
+		f.forget()
+	}
+	return nil, false, ctx.Err()
+}
+
+// SetDetach sets whether loads keep running after all callers give up, see
+// LoadAndFillContext. Detaching is enabled by default.
+func (l *Loader) SetDetach(detach bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.detach = detach
+}
+
+// SetMaxProc sets the maximum number of goroutines call ProxyLoader.
+func (l *Loader) SetMaxProc(maxProc int) {
+	l.limit.SetMax(clampMaxProc(maxProc))
+}
+
+// SetClock sets the clock of the miss filter, so it can be tested by a fake
+// clock. It should be called before SetMissFilter.
+func (l *Loader) SetClock(clk clock.Clock) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.clock = clk
+}
+
+// SetLabels sets pprof labels of the goroutine calling ProxyLoader, so
+// profiles can be attributed to Loaders in a process hosting several.
+// Label "loader" is name, and label "namespace" is returned by namespace for
+// the key loading, unless namespace is nil. If name is empty and namespace is
+// nil, no labels are set.
+// namespace is called with Loader locked, so it must not call Loader's methods.
+func (l *Loader) SetLabels(name string, namespace func(key string) string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.name = name
+	l.namespace = namespace
+}
+
+func (l *Loader) labels(key string) []string {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.labelsWithLock(key)
+}
+
+func (l *Loader) labelsWithLock(key string) []string {
+	var labels []string
+	if l.name != "" {
+		labels = append(labels, "loader", l.name)
+	}
+	if l.namespace != nil {
+		labels = append(labels, "namespace", l.namespace(key))
+	}
+	return labels
+}
+
+// SetOnJoin sets a handler which is called when a load joins an in-flight load
+// of the same key, instead of loading again. It is a hook for tests to observe
+// duplicate loads deterministically, see proxytest.Barrier.
+func (l *Loader) SetOnJoin(f func(key string)) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.onJoin = f
+}
+
+// SetMissFilter enables a bloom filter of keys which ProxyLoader reports not
+// ok, so loading them again is skipped without calling ProxyLoader.
+// The filter holds about n keys with a false positive rate of fp, and forgets
+// keys after at most two intervals. If n is 0, the filter is disabled.
+// Keys written to database should be removed from the filter by ForgetMissing.
+func (l *Loader) SetMissFilter(n int, fp float64, interval time.Duration) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	var f *missFilter
+	if n > 0 && interval > 0 {
+		f = newMissFilter(n, fp, interval, l.clock)
+	}
+
+	if l.missing != nil {
+		l.missing.close()
+	}
+	l.missing = f
+}
+
+// ForgetMissing removes a key from the miss filter, see SetMissFilter.
+// In-flight load of the key will not be put into the filter.
+func (l *Loader) ForgetMissing(key string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.missing == nil {
+		return
+	}
+	if f, ok := l.inFlight[key]; ok {
+		f.written = true
+	}
+	l.missing.forget(key)
+}
+
+// Forget forgets the in-flight load of the provided key.
+// The loading result will not be filled, and the next Load of the key will do
+// a new loading.
+// If the result is being filled, Forget waits for it, so it is never filled
+// after Forget returns.
+func (l *Loader) Forget(key string) {
+	l.mtx.Lock()
+	f, ok := l.inFlight[key]
 	delete(l.inFlight, key)
 	l.mtx.Unlock()
 
-	return f.value, f.ok
+	if ok {
+		f.forget()
+	}
+}
+
+// ForgetAll forgets all in-flight loads like Forget.
+func (l *Loader) ForgetAll() {
+	l.mtx.Lock()
+	forgotten := make([]*loadResult, 0, len(l.inFlight))
+	for key, f := range l.inFlight {
+		forgotten = append(forgotten, f)
+		delete(l.inFlight, key)
+	}
+	l.mtx.Unlock()
+
+	for _, f := range forgotten {
+		f.forget()
+	}
 }
 
 // LoaderStatus is used for runtime performance profiling.
@@ -74,18 +427,51 @@ type LoaderStatus struct {
 	MaxLoaderProc int `json:"maxLoaderProc"`
 	LoaderProc    int `json:"loaderProc"`
 	InflightLoad  int `json:"inflightLoad"`
+	BlockedLoad   int `json:"blockedLoad"` // loads waiting for goroutines of maxProc or tenants
+	JoinedLoad    int `json:"joinedLoad"`  // callers waiting for in-flight loads of the same keys
+
+	FilteredLoad  uint64 `json:"filteredLoad"`  // loads skipped by the miss filter
+	RejectedLoad  uint64 `json:"rejectedLoad"`  // loads over tenants' in-flight limits
+	AbandonedLoad uint64 `json:"abandonedLoad"` // waits given up by callers' contexts
+	ThrottledLoad uint64 `json:"throttledLoad"` // loads waited for bandwidth
+	LoadedBytes   uint64 `json:"loadedBytes"`   // only counted with bandwidth limited
+
+	Tenants map[string]TenantStatus `json:"tenants,omitempty"`
 }
 
 // Status returns Loader's runtime performance status.
 func (l *Loader) Status() LoaderStatus {
 	l.mtx.Lock()
 	defer l.mtx.Unlock()
-	l.proc.mtx.Lock()
-	defer l.proc.mtx.Unlock()
 
-	return LoaderStatus{
-		MaxLoaderProc: l.proc.maxProc,
-		LoaderProc:    l.proc.maxProc - len(l.proc.start),
+	ls := l.limit.Stats()
+	s := LoaderStatus{
+		MaxLoaderProc: ls.Max,
+		LoaderProc:    ls.InUse,
 		InflightLoad:  len(l.inFlight),
+		BlockedLoad:   ls.Waiting,
+		JoinedLoad:    l.joined,
+		RejectedLoad:  l.rejected,
+		AbandonedLoad: l.abandoned,
+	}
+	if len(l.tenants) > 0 {
+		s.Tenants = make(map[string]TenantStatus, len(l.tenants))
+		for name, t := range l.tenants {
+			ts := t.status()
+			s.Tenants[name] = ts
+			s.BlockedLoad += ts.Waiting
+		}
+	}
+	if l.bandwidth != nil {
+		l.bandwidth.mtx.Lock()
+		s.ThrottledLoad = l.bandwidth.throttled
+		s.LoadedBytes = l.bandwidth.bytes
+		l.bandwidth.mtx.Unlock()
+	}
+	if l.missing != nil {
+		l.missing.mtx.Lock()
+		s.FilteredLoad = l.missing.filtered
+		l.missing.mtx.Unlock()
 	}
+	return s
 }
@@ -0,0 +1,147 @@
+package proxy_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huangml/proxycache/proxy"
+	"github.com/huangml/proxycache/proxytest"
+)
+
+func TestLoadJoined(t *testing.T) {
+	db := proxytest.NewLoader()
+	db.Set("k", []byte("v"))
+	b := proxytest.NewBarrier(db)
+	l := proxy.NewLoader(b, 4)
+
+	joined := make(chan string, 2)
+	l.SetOnJoin(func(key string) { joined <- key })
+
+	var mtx sync.Mutex
+	fills := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, ok := l.LoadAndFill("k", func(value []byte, ok bool) {
+				mtx.Lock()
+				fills++
+				mtx.Unlock()
+			})
+			if string(value) != "v" || !ok {
+				t.Errorf("LoadAndFill() = %q, %v", value, ok)
+			}
+		}()
+	}
+	b.Wait(1)
+	<-joined
+	<-joined
+	b.ReleaseAll()
+	wg.Wait()
+
+	db.AssertLoadedOnce(t, "k")
+	if fills != 1 {
+		t.Errorf("fill called %d times, want 1", fills)
+	}
+}
+
+func TestForgetDuringLoad(t *testing.T) {
+	db := proxytest.NewLoader()
+	db.Set("k", []byte("v"))
+	b := proxytest.NewBarrier(db)
+	l := proxy.NewLoader(b, 4)
+
+	filled := make(chan bool, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		value, ok := l.LoadAndFill("k", func(value []byte, ok bool) { filled <- ok })
+		if string(value) != "v" || !ok {
+			t.Errorf("LoadAndFill() = %q, %v", value, ok)
+		}
+	}()
+	b.Wait(1)
+	l.Forget("k")
+	b.ReleaseAll()
+	<-done
+
+	select {
+	case <-filled:
+		t.Error("fill called after the key is forgotten")
+	default:
+	}
+
+	// loads after forgetting are not joined with the forgotten one.
+	go l.Load("k")
+	b.Wait(1)
+	b.ReleaseAll()
+	waitFor(t, func() bool { return db.Count("k") == 2 })
+}
+
+func TestForgetWaitsForFill(t *testing.T) {
+	db := proxytest.NewLoader()
+	l := proxy.NewLoader(db, 4)
+
+	filling := make(chan struct{})
+	finish := make(chan struct{})
+	go l.LoadAndFill("k", func(value []byte, ok bool) {
+		close(filling)
+		<-finish
+	})
+	<-filling
+
+	forgotten := make(chan struct{})
+	go func() {
+		l.Forget("k")
+		close(forgotten)
+	}()
+	select {
+	case <-forgotten:
+		t.Fatal("Forget() returned while filling")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(finish)
+	<-forgotten
+}
+
+func TestLoadAbandoned(t *testing.T) {
+	db := proxytest.NewLoader()
+	db.Set("k", []byte("v"))
+	b := proxytest.NewBarrier(db)
+	l := proxy.NewLoader(b, 4)
+	l.SetDetach(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, _, err := l.LoadAndFillContext(ctx, "k", func(value []byte, ok bool) {
+			t.Error("fill called for an abandoned load")
+		})
+		done <- err
+	}()
+	b.Wait(1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("LoadAndFillContext() = %v, want %v", err, context.Canceled)
+	}
+
+	// the load is canceled, and leaves the barrier without loading.
+	waitFor(t, func() bool { return len(b.Blocked()) == 0 })
+	db.AssertNotLoaded(t, "k")
+	if s := l.Status(); s.AbandonedLoad != 1 {
+		t.Errorf("AbandonedLoad = %d, want 1", s.AbandonedLoad)
+	}
+}
+
+// waitFor waits until f returns true, failing t after a while.
+func waitFor(t *testing.T, f func() bool) {
+	t.Helper()
+	for start := time.Now(); !f(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timed out")
+		}
+	}
+}
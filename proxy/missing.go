@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/huangml/proxycache/bloom"
+	"github.com/huangml/proxycache/clock"
+)
+
+// missFilter remembers keys confirmed not existing by ProxyLoader.
+// It holds two generations of bloom filters, rotated every interval, so a key
+// is forgotten after two intervals at most.
+type missFilter struct {
+	mtx      sync.Mutex
+	cur      *bloom.Filter
+	prev     *bloom.Filter
+	stop     chan struct{}
+	filtered uint64
+}
+
+func newMissFilter(n int, fp float64, interval time.Duration, clk clock.Clock) *missFilter {
+	f := &missFilter{
+		cur:  bloom.New(n, fp),
+		prev: bloom.New(n, fp),
+		stop: make(chan struct{}),
+	}
+
+	t := clk.NewTimer(interval)
+	go func() {
+		defer t.Stop()
+		for {
+			select {
+			case <-f.stop:
+				return
+			case <-t.C():
+				f.rotate()
+				t.Reset(interval)
+			}
+		}
+	}()
+
+	return f
+}
+
+func (f *missFilter) rotate() {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.prev, f.cur = f.cur, f.prev
+	f.cur.Reset()
+}
+
+// test tells whether key may be missing, and counts it if so.
+func (f *missFilter) test(key string) bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.cur.Test(key) || f.prev.Test(key) {
+		f.filtered++
+		return true
+	}
+	return false
+}
+
+func (f *missFilter) add(key string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	// keep the false positive rate by rotating early.
+	if f.cur.Len() >= f.cur.Cap() {
+		f.prev, f.cur = f.cur, f.prev
+		f.cur.Reset()
+	}
+	f.cur.Add(key)
+}
+
+// forget makes key not missing. Keys can't be removed from bloom filters, so
+// filters are reset if key may be in them.
+func (f *missFilter) forget(key string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.cur.Test(key) || f.prev.Test(key) {
+		f.cur.Reset()
+		f.prev.Reset()
+	}
+}
+
+func (f *missFilter) close() {
+	close(f.stop)
+}
@@ -0,0 +1,302 @@
+package proxy
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/huangml/proxycache/clock"
+	"github.com/huangml/proxycache/limiter"
+	"github.com/huangml/proxycache/ring"
+)
+
+// Backend is a ProxyLoader in a Pool.
+type Backend struct {
+	Name   string
+	Loader ProxyLoader
+	// Health tells whether the backend is healthy, see Pool.SetHealthCheck.
+	// If it is nil, the backend is always healthy.
+	Health func() bool
+	// Weight is the share of loads the backend receives, among backends of
+	// positive weights. A backend of weight 0 only receives fallbacks.
+	Weight int
+	// MaxProc is the maximum number of concurrent loads of the backend.
+	// If it is 0, there is no limit.
+	MaxProc int
+}
+
+// Pool is a ProxyLoader loads data from a chain of backends. A load falls
+// back to the next backend if the previous one fails.
+// Loads are balanced among backends of positive weights, in proportion to
+// their weights, and preferring ones not reaching their MaxProc. If no backend
+// has a weight, loads start from the first backend of the chain.
+// Unhealthy backends are taken out of the chain until they are healthy
+// again, see SetHealthCheck.
+type Pool struct {
+	backends []*poolBackend
+
+	mtx       sync.Mutex
+	clock     clock.Clock
+	stopCheck chan struct{}
+	replicas  int                     // see SetSticky
+	ring      *ring.Ring              // nil if not sticky
+	sticky    map[string]*poolBackend // backends on ring by names
+}
+
+type poolBackend struct {
+	Backend
+	limit *limiter.Limiter // nil if no MaxProc
+
+	mtx       sync.Mutex
+	healthy   bool
+	loads     uint64
+	failures  uint64
+	lastCheck time.Time
+}
+
+// NewPool creates a Pool of backends, in the order of the fallback chain.
+// All backends are healthy before checked.
+func NewPool(backends []Backend) *Pool {
+	p := &Pool{clock: clock.Real}
+	for _, b := range backends {
+		pb := &poolBackend{Backend: b, healthy: true}
+		if b.MaxProc > 0 {
+			pb.limit = limiter.New(b.MaxProc)
+		}
+		p.backends = append(p.backends, pb)
+	}
+	return p
+}
+
+// SetClock sets the clock of health checks, so they can be tested by a fake
+// clock. It should be called before SetHealthCheck.
+func (p *Pool) SetClock(clk clock.Clock) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.clock = clk
+}
+
+// SetHealthCheck starts calling Health of backends every interval. A backend
+// reported unhealthy is skipped by loads until it is reported healthy.
+// If interval is 0, health checks are stopped, and all backends are treated
+// as healthy.
+func (p *Pool) SetHealthCheck(interval time.Duration) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.stopCheck != nil {
+		close(p.stopCheck)
+		p.stopCheck = nil
+	}
+	if interval <= 0 {
+		for _, b := range p.backends {
+			b.setHealthy(true, time.Time{})
+		}
+		p.syncRingWithLock()
+		return
+	}
+
+	stop := make(chan struct{})
+	p.stopCheck = stop
+	clk := p.clock
+	t := clk.NewTimer(interval)
+
+	go func() {
+		defer t.Stop()
+		p.check(clk)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C():
+				p.check(clk)
+				t.Reset(interval)
+			}
+		}
+	}()
+}
+
+// check checks health of all backends, and rebuilds the ring if any of them
+// changes.
+func (p *Pool) check(clk clock.Clock) {
+	changed := false
+	for _, b := range p.backends {
+		if b.Health != nil && b.setHealthy(b.Health(), clk.Now()) {
+			changed = true
+		}
+	}
+	if changed {
+		p.mtx.Lock()
+		p.syncRingWithLock()
+		p.mtx.Unlock()
+	}
+}
+
+// setHealthy sets the health of the backend, it returns true if it is changed.
+func (b *poolBackend) setHealthy(healthy bool, now time.Time) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	changed := b.healthy != healthy
+	b.healthy = healthy
+	b.lastCheck = now
+	return changed
+}
+
+func (b *poolBackend) isHealthy() bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.healthy
+}
+
+// chain returns backends to try in order. If no backend is healthy, all are
+// tried, rather than failing every load.
+func (p *Pool) chain() []*poolBackend {
+	var chain []*poolBackend
+	for _, b := range p.backends {
+		if b.isHealthy() {
+			chain = append(chain, b)
+		}
+	}
+	if len(chain) == 0 {
+		return p.backends
+	}
+	return chain
+}
+
+// Load loads data from a healthy backend, falling back to the others in the
+// order of the chain if it fails. A Pool of no backends fails every load.
+func (p *Pool) Load(key string) ([]byte, bool) {
+	return p.LoadContext(context.Background(), key)
+}
+
+// LoadContext loads data like Load, passing ctx to backends which are
+// ContextProxyLoaders. It stops falling back once ctx is done.
+func (p *Pool) LoadContext(ctx context.Context, key string) ([]byte, bool) {
+	chain := p.chain()
+	if len(chain) == 0 {
+		return nil, false
+	}
+	var first *poolBackend
+	if routed := p.route(key, chain); len(routed) > 0 {
+		chain = routed
+		first = chain[0]
+	} else {
+		first = pick(chain)
+	}
+	if value, ok := first.load(ctx, key); ok {
+		return value, true
+	}
+	for _, b := range chain {
+		if ctx.Err() != nil {
+			break
+		}
+		if b == first {
+			continue
+		}
+		if value, ok := b.load(ctx, key); ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// pick picks the backend a load starts from, by weights among backends not
+// busy, or all weighted backends if they are all busy.
+func pick(chain []*poolBackend) *poolBackend {
+	var weighted, idle []*poolBackend
+	for _, b := range chain {
+		if b.Weight <= 0 {
+			continue
+		}
+		weighted = append(weighted, b)
+		if b.limit == nil || b.limit.Stats().InUse < b.MaxProc {
+			idle = append(idle, b)
+		}
+	}
+	if len(idle) > 0 {
+		return pickWeighted(idle)
+	}
+	if len(weighted) > 0 {
+		return pickWeighted(weighted)
+	}
+	return chain[0]
+}
+
+func pickWeighted(backends []*poolBackend) *poolBackend {
+	total := 0
+	for _, b := range backends {
+		total += b.Weight
+	}
+	n := rand.Intn(total)
+	for _, b := range backends {
+		if n < b.Weight {
+			return b
+		}
+		n -= b.Weight
+	}
+	return backends[len(backends)-1]
+}
+
+func (b *poolBackend) load(ctx context.Context, key string) ([]byte, bool) {
+	if b.limit != nil {
+		if b.limit.AcquireContext(ctx) != nil {
+			return nil, false
+		}
+		defer b.limit.Release()
+	}
+	value, ok := LoadContext(ctx, b.Loader, key)
+
+	b.mtx.Lock()
+	b.loads++
+	if !ok {
+		b.failures++
+	}
+	b.mtx.Unlock()
+
+	return value, ok
+}
+
+// BackendStatus is the status of a backend in Pool.
+type BackendStatus struct {
+	Name      string `json:"name"`
+	Healthy   bool   `json:"healthy"`
+	Weight    int    `json:"weight"`
+	MaxProc   int    `json:"maxProc"`
+	Proc      int    `json:"proc"`
+	Loads     uint64 `json:"loads"`
+	Failures  uint64 `json:"failures"`
+	LastCheck int64  `json:"lastCheck"` // unix epoch time
+}
+
+// PoolStatus is used for runtime performance profiling.
+type PoolStatus struct {
+	Backends []BackendStatus `json:"backends"`
+}
+
+// Status returns Pool's runtime performance status.
+func (p *Pool) Status() PoolStatus {
+	var s PoolStatus
+	for _, b := range p.backends {
+		b.mtx.Lock()
+		bs := BackendStatus{
+			Name:      b.Name,
+			Healthy:   b.healthy,
+			Weight:    b.Weight,
+			MaxProc:   b.MaxProc,
+			Loads:     b.loads,
+			Failures:  b.failures,
+			LastCheck: unix(b.lastCheck),
+		}
+		b.mtx.Unlock()
+
+		if b.limit != nil {
+			bs.Proc = b.limit.Stats().InUse
+		}
+		s.Backends = append(s.Backends, bs)
+	}
+	return s
+}
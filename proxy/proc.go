@@ -23,11 +23,7 @@ func newProc(maxProc int) *proc {
 
 // SetMaxProc sets max number of goroutines.
 func (p *proc) SetMaxProc(maxProc int) {
-	if maxProc > MaxOfMaxProc {
-		maxProc = MaxOfMaxProc
-	} else if maxProc < 0 {
-		maxProc = 0
-	}
+	maxProc = clampMaxProc(maxProc)
 
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
@@ -47,3 +43,12 @@ func (p *proc) SetMaxProc(maxProc int) {
 		}
 	}()
 }
+
+func clampMaxProc(maxProc int) int {
+	if maxProc > MaxOfMaxProc {
+		return MaxOfMaxProc
+	} else if maxProc < 0 {
+		return 0
+	}
+	return maxProc
+}
@@ -1,10 +1,12 @@
 package proxy
 
 import (
+	"context"
 	"sync"
 	"time"
 
 	"github.com/huangml/proxycache/cache"
+	"github.com/huangml/proxycache/clock"
 )
 
 // ProxySaver is the interface wraps the Save method.
@@ -12,18 +14,50 @@ type ProxySaver interface {
 	Save(key string, value []byte) (ok bool)
 }
 
+// ProxyDeleter is the interface wraps the Delete method.
+// A ProxySaver implements it is able to save deletions, see Entry.Deleted.
+type ProxyDeleter interface {
+	Delete(key string) (ok bool)
+}
+
+// BatchProxySaver is the interface wraps the SaveBatch method.
+// A ProxySaver implements it is able to save multiple entries in one call,
+// see Saver.SetMaxBatch.
+type BatchProxySaver interface {
+	ProxySaver
+	SaveBatch(keys []string, values [][]byte) (ok []bool)
+}
+
 // Buffer is the interface of a save buffer.
 type Buffer interface {
 	Entries() <-chan *cache.Entry
 	OnSave(entry *cache.Entry, ok bool)
+	OnBusy(entry *cache.Entry)
+	Flush()
+	Pending() int
 }
 
 // Saver calls ProxySaver concurrently to save entries from Buffer.
+// Entries of the same key are never saved concurrently, saving of a key waits
+// until the previous one is done, so they reach ProxySaver in the order they
+// were pumped by Buffer.
 type Saver struct {
 	*proc
+	buffer Buffer
 
 	mtx      sync.Mutex
 	inFlight map[string]struct{}
+	maxBatch int
+
+	succeeded uint64
+	failed    uint64
+	lastSave  time.Time
+
+	clock  clock.Clock
+	onSave func(entry *cache.Entry, ok bool)
+	saved  chan struct{} // closed and renewed after each save, see Flush
+	stop   chan struct{} // closed by Close
+	closed bool
 }
 
 // NewSaver creates a Saver.
@@ -32,24 +66,38 @@ type Saver struct {
 func NewSaver(p ProxySaver, maxProc int, buffer Buffer) *Saver {
 	s := &Saver{
 		proc:     newProc(maxProc),
+		buffer:   buffer,
 		inFlight: make(map[string]struct{}),
+		maxBatch: 1,
+		clock:    clock.Real,
+		saved:    make(chan struct{}),
+		stop:     make(chan struct{}),
 	}
 
 	pipe := make(chan *cache.Entry)
 
 	// Fetch entries from Buffer and redirect to pipe.
-	// If the given key is in saving, simply reports saving fail.
+	// If the given key is in saving, simply reports busy.
 	go func() {
 		for {
-			entry := <-buffer.Entries()
+			var entry *cache.Entry
+			select {
+			case entry = <-buffer.Entries():
+			case <-s.stop:
+				return
+			}
 			s.mtx.Lock()
 			if _, saving := s.inFlight[entry.Key]; saving {
 				s.mtx.Unlock()
-				buffer.OnSave(entry, false)
+				buffer.OnBusy(entry)
 			} else {
 				s.inFlight[entry.Key] = struct{}{}
 				s.mtx.Unlock()
-				pipe <- entry
+				select {
+				case pipe <- entry:
+				case <-s.stop:
+					return
+				}
 			}
 
 		}
@@ -59,7 +107,11 @@ func NewSaver(p ProxySaver, maxProc int, buffer Buffer) *Saver {
 	go func() {
 		for {
 			// start a worker when get a start signal
-			<-s.start
+			select {
+			case <-s.start:
+			case <-s.stop:
+				return
+			}
 			go func() {
 				for {
 					select {
@@ -67,16 +119,39 @@ func NewSaver(p ProxySaver, maxProc int, buffer Buffer) *Saver {
 					case <-s.quit:
 						return
 					case entry := <-pipe:
-						ok := p.Save(entry.Key, entry.Value)
-						buffer.OnSave(entry, ok)
+						entries := s.batch(entry, pipe)
+						oks := save(p, entries)
+
+						failed := false
+						for i, entry := range entries {
+							buffer.OnSave(entry, oks[i])
+							failed = failed || !oks[i]
+						}
 
 						// remove from inFlight
 						s.mtx.Lock()
-						delete(s.inFlight, entry.Key)
+						for i, entry := range entries {
+							delete(s.inFlight, entry.Key)
+							if oks[i] {
+								s.succeeded++
+								s.lastSave = s.clock.Now()
+							} else {
+								s.failed++
+							}
+						}
+						onSave, clk := s.onSave, s.clock
+						close(s.saved)
+						s.saved = make(chan struct{})
 						s.mtx.Unlock()
 
-						if !ok {
-							time.Sleep(time.Second)
+						if onSave != nil {
+							for i, entry := range entries {
+								onSave(entry, oks[i])
+							}
+						}
+
+						if failed {
+							<-clk.After(time.Second)
 						}
 					}
 				}
@@ -87,23 +162,168 @@ func NewSaver(p ProxySaver, maxProc int, buffer Buffer) *Saver {
 	return s
 }
 
+// Flush saves all pending entries in Buffer synchronously.
+// It returns ctx.Err() if ctx is done before all entries are saved.
+// Dead letters are not waited.
+func (s *Saver) Flush(ctx context.Context) error {
+	for {
+		// take the signal before checking, so saves meanwhile are not missed.
+		s.mtx.Lock()
+		saved := s.saved
+		s.mtx.Unlock()
+
+		s.buffer.Flush()
+		if s.buffer.Pending() == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-saved:
+		}
+	}
+}
+
+// Close flushes pending entries like Flush, then stops all workers.
+// Entries put into Buffer after closing will not be saved.
+func (s *Saver) Close(ctx context.Context) error {
+	err := s.Flush(ctx)
+
+	s.mtx.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.stop)
+	}
+	s.mtx.Unlock()
+
+	s.SetMaxProc(0)
+	return err
+}
+
+// SetClock sets the clock of status and backing off after failures, so they
+// can be tested by a fake clock.
+func (s *Saver) SetClock(clk clock.Clock) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.clock = clk
+}
+
+// SetOnSave sets a handler which is called after each entry is saved.
+func (s *Saver) SetOnSave(f func(entry *cache.Entry, ok bool)) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.onSave = f
+}
+
+// SetMaxBatch sets the maximum number of entries saved in one call.
+// It only takes effect if ProxySaver implements BatchProxySaver.
+func (s *Saver) SetMaxBatch(maxBatch int) {
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.maxBatch = maxBatch
+}
+
+// batch collects entries already in pipe, up to maxBatch.
+func (s *Saver) batch(entry *cache.Entry, pipe <-chan *cache.Entry) []*cache.Entry {
+	s.mtx.Lock()
+	maxBatch := s.maxBatch
+	s.mtx.Unlock()
+
+	entries := []*cache.Entry{entry}
+	for len(entries) < maxBatch {
+		select {
+		case entry := <-pipe:
+			entries = append(entries, entry)
+		default:
+			return entries
+		}
+	}
+	return entries
+}
+
+// save saves entries, deletions are saved by ProxyDeleter one by one.
+// Deletions are treated as saved if ProxySaver is not a ProxyDeleter.
+func save(p ProxySaver, entries []*cache.Entry) []bool {
+	oks := make([]bool, len(entries))
+
+	var batch []int
+	for i, entry := range entries {
+		if entry.Deleted {
+			if d, ok := p.(ProxyDeleter); ok {
+				oks[i] = d.Delete(entry.Key)
+			} else {
+				oks[i] = true
+			}
+		} else {
+			batch = append(batch, i)
+		}
+	}
+
+	if b, ok := p.(BatchProxySaver); ok && len(batch) > 1 {
+		keys := make([]string, len(batch))
+		values := make([][]byte, len(batch))
+		for n, i := range batch {
+			keys[n], values[n] = entries[i].Key, entries[i].Value
+		}
+		batchOks := b.SaveBatch(keys, values)
+		for n, i := range batch {
+			oks[i] = n < len(batchOks) && batchOks[n]
+		}
+		return oks
+	}
+
+	for _, i := range batch {
+		oks[i] = p.Save(entries[i].Key, entries[i].Value)
+	}
+	return oks
+}
+
 // SaverStatus is used for runtime performance profiling.
 type SaverStatus struct {
-	MaxSaverProc int `json:"maxSaverProc"`
-	SaverProc    int `json:"saverProc"`
-	InflightSave int `json:"inflightSave"`
+	MaxSaverProc  int `json:"maxSaverProc"`
+	SaverProc     int `json:"saverProc"`
+	InflightSave  int `json:"inflightSave"`
+	MaxSaverBatch int `json:"maxSaverBatch"`
+	QueuedSave    int `json:"queuedSave"` // entries in Buffer not saved yet
+
+	SucceededSaves uint64 `json:"succeededSaves"`
+	FailedSaves    uint64 `json:"failedSaves"`
+	LastSave       int64  `json:"lastSave"` // unix epoch time of the last succeeded save
 }
 
 // Status returns Saver's runtime performance status.
 func (s *Saver) Status() SaverStatus {
+	queued := s.buffer.Pending()
+
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 	s.proc.mtx.Lock()
 	defer s.proc.mtx.Unlock()
 
 	return SaverStatus{
-		MaxSaverProc: s.proc.maxProc,
-		SaverProc:    s.proc.maxProc - len(s.proc.start),
-		InflightSave: len(s.inFlight),
+		MaxSaverProc:  s.proc.maxProc,
+		SaverProc:     s.proc.maxProc - len(s.proc.start),
+		InflightSave:  len(s.inFlight),
+		MaxSaverBatch: s.maxBatch,
+		QueuedSave:    queued,
+
+		SucceededSaves: s.succeeded,
+		FailedSaves:    s.failed,
+		LastSave:       unix(s.lastSave),
+	}
+}
+
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
 	}
+	return t.Unix()
 }
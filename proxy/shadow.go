@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"sync"
+)
+
+// Diff is a difference found by Shadow.
+type Diff struct {
+	Key         string
+	Primary     []byte
+	PrimaryOK   bool
+	Secondary   []byte
+	SecondaryOK bool
+}
+
+// Shadow is a ProxyLoader loads data from a primary ProxyLoader, and also
+// from a secondary one asynchronously to compare with.
+// Data returned is always from the primary, the secondary is only used to
+// report differences, e.g. for verifying a database migration.
+type Shadow struct {
+	primary   ProxyLoader
+	secondary ProxyLoader
+	onDiff    func(d Diff)
+	slots     chan struct{}
+
+	mtx      sync.Mutex
+	compared uint64
+	differed uint64
+	dropped  uint64
+}
+
+// NewShadow creates a Shadow.
+// Parameter maxProc specifies the maximum number of concurrent secondary
+// loads, the excess are dropped without comparing, so that the secondary
+// never slows down the primary. onDiff is called on every difference.
+func NewShadow(primary, secondary ProxyLoader, maxProc int, onDiff func(d Diff)) *Shadow {
+	if maxProc < 1 {
+		maxProc = 1
+	}
+	return &Shadow{
+		primary:   primary,
+		secondary: secondary,
+		onDiff:    onDiff,
+		slots:     make(chan struct{}, maxProc),
+	}
+}
+
+// Load loads data from the primary, and compares it with the secondary in
+// background.
+func (s *Shadow) Load(key string) ([]byte, bool) {
+	return s.LoadContext(context.Background(), key)
+}
+
+// LoadContext loads data like Load, passing ctx to the primary if it is a
+// ContextProxyLoader. The secondary is not canceled with ctx, so comparing
+// finishes after the caller gives up.
+func (s *Shadow) LoadContext(ctx context.Context, key string) ([]byte, bool) {
+	value, ok := LoadContext(ctx, s.primary, key)
+
+	select {
+	case s.slots <- struct{}{}:
+		go func() {
+			defer func() { <-s.slots }()
+			s.compare(key, value, ok)
+		}()
+	default:
+		s.mtx.Lock()
+		s.dropped++
+		s.mtx.Unlock()
+	}
+
+	return value, ok
+}
+
+func (s *Shadow) compare(key string, value []byte, ok bool) {
+	sv, sok := s.secondary.Load(key)
+	differ := ok != sok || (ok && !bytes.Equal(value, sv))
+
+	s.mtx.Lock()
+	s.compared++
+	if differ {
+		s.differed++
+	}
+	s.mtx.Unlock()
+
+	if differ && s.onDiff != nil {
+		s.onDiff(Diff{
+			Key:         key,
+			Primary:     value,
+			PrimaryOK:   ok,
+			Secondary:   sv,
+			SecondaryOK: sok,
+		})
+	}
+}
+
+// ShadowStatus is used for runtime performance profiling.
+type ShadowStatus struct {
+	ShadowCompared uint64 `json:"shadowCompared"`
+	ShadowDiffered uint64 `json:"shadowDiffered"`
+	ShadowDropped  uint64 `json:"shadowDropped"`
+}
+
+// Status returns Shadow's runtime performance status.
+func (s *Shadow) Status() ShadowStatus {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return ShadowStatus{
+		ShadowCompared: s.compared,
+		ShadowDiffered: s.differed,
+		ShadowDropped:  s.dropped,
+	}
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import "github.com/huangml/proxycache/ring"
+
+// SetSticky routes each key to the same healthy backend by consistent hashing,
+// so backends see the same keys and cache them well. Backends are placed on
+// the ring by their weights, or equally if no backend has a weight, with
+// replicas virtual nodes per unit of weight. When a backend turns unhealthy,
+// only its keys are routed to others, and a failed load falls back along the
+// ring. Backend names must be unique.
+// If replicas is 0, sticky routing is disabled.
+func (p *Pool) SetSticky(replicas int) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.replicas = replicas
+	p.syncRingWithLock()
+}
+
+// syncRingWithLock rebuilds the ring from the chain, it is called when
+// backends change their health. The ring is replaced rather than changed, so
+// loads routing by the old one are not affected.
+func (p *Pool) syncRingWithLock() {
+	p.ring, p.sticky = nil, nil
+	if p.replicas <= 0 {
+		return
+	}
+
+	weighted := false
+	for _, b := range p.backends {
+		weighted = weighted || b.Weight > 0
+	}
+	r := ring.New(p.replicas)
+	sticky := make(map[string]*poolBackend)
+	for _, b := range p.chain() {
+		weight := b.Weight
+		if !weighted {
+			weight = 1
+		}
+		if weight > 0 {
+			r.Set(b.Name, weight)
+			sticky[b.Name] = b
+		}
+	}
+	p.ring, p.sticky = r, sticky
+}
+
+// route returns chain ordered by the ring for key, or nil if sticky routing
+// is disabled. Backends not on the ring are left at the end, in chain order.
+func (p *Pool) route(key string, chain []*poolBackend) []*poolBackend {
+	p.mtx.Lock()
+	r, sticky := p.ring, p.sticky
+	p.mtx.Unlock()
+	if r == nil {
+		return nil
+	}
+
+	routed := make([]*poolBackend, 0, len(chain))
+	for _, name := range r.GetN(key, len(sticky)) {
+		routed = append(routed, sticky[name])
+	}
+	for _, b := range chain {
+		if _, ok := sticky[b.Name]; !ok {
+			routed = append(routed, b)
+		}
+	}
+	return routed
+}
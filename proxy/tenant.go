@@ -0,0 +1,134 @@
+package proxy
+
+import "github.com/huangml/proxycache/limiter"
+
+// tenant limits loads of keys belonging to it, see SetTenantLimit.
+type tenant struct {
+	limit       *limiter.Limiter // nil if not limited
+	maxInflight int
+	inflight    int
+	rejected    uint64
+	explicit    bool // set by SetTenantLimit, otherwise by the default limit
+}
+
+func newTenant(maxProc, maxInflight int) *tenant {
+	t := &tenant{maxInflight: maxInflight}
+	if maxProc > 0 {
+		t.limit = limiter.New(maxProc)
+	}
+	return t
+}
+
+func (t *tenant) setLimit(maxProc, maxInflight int) {
+	t.maxInflight = maxInflight
+	if maxProc <= 0 {
+		// loads holding slots still release them to the old limiter
+		t.limit = nil
+	} else if t.limit == nil {
+		t.limit = limiter.New(maxProc)
+	} else {
+		t.limit.SetMax(maxProc)
+	}
+}
+
+// SetTenant sets the function telling which tenant a key belongs to, for
+// example by its prefix, see SetTenantLimit.
+// f is called with Loader locked, so it must not call Loader's methods.
+func (l *Loader) SetTenant(f func(key string) string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.tenantOf = f
+}
+
+// SetTenantLimit limits loads of a tenant, so one tenant can't take all
+// goroutines of Loader. At most maxProc goroutines call ProxyLoader for the
+// tenant, and at most maxInflight distinct keys of the tenant are loading,
+// excess loads are rejected as not ok without calling ProxyLoader. Loads
+// joining an in-flight load of the same key are not limited. 0 means no limit.
+// If both are 0, the tenant falls back to the default limit, see
+// SetDefaultTenantLimit.
+func (l *Loader) SetTenantLimit(tenant string, maxProc, maxInflight int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	t, ok := l.tenants[tenant]
+	if maxProc <= 0 && maxInflight <= 0 {
+		if ok {
+			t.explicit = false
+			t.setLimit(l.tenantMaxProc, l.tenantMaxInflight)
+			l.pruneTenantWithLock(tenant, t)
+		}
+		return
+	}
+	if !ok {
+		t = newTenant(maxProc, maxInflight)
+		l.tenants[tenant] = t
+	} else {
+		t.setLimit(maxProc, maxInflight)
+	}
+	t.explicit = true
+}
+
+// SetDefaultTenantLimit sets the limit of tenants without their own limit, see
+// SetTenantLimit. Each tenant is limited separately.
+func (l *Loader) SetDefaultTenantLimit(maxProc, maxInflight int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.tenantMaxProc, l.tenantMaxInflight = maxProc, maxInflight
+	for name, t := range l.tenants {
+		if !t.explicit {
+			t.setLimit(maxProc, maxInflight)
+			l.pruneTenantWithLock(name, t)
+		}
+	}
+}
+
+// tenantWithLock returns the tenant of a key, or nil if it is not limited.
+func (l *Loader) tenantWithLock(key string) (string, *tenant) {
+	if l.tenantOf == nil {
+		return "", nil
+	}
+	name := l.tenantOf(key)
+	if t, ok := l.tenants[name]; ok {
+		return name, t
+	}
+	if l.tenantMaxProc <= 0 && l.tenantMaxInflight <= 0 {
+		return name, nil
+	}
+	t := newTenant(l.tenantMaxProc, l.tenantMaxInflight)
+	l.tenants[name] = t
+	return name, t
+}
+
+// pruneTenantWithLock forgets an idle tenant limited by the default limit, it
+// will be created again on its next load.
+func (l *Loader) pruneTenantWithLock(name string, t *tenant) {
+	if !t.explicit && t.inflight == 0 {
+		delete(l.tenants, name)
+	}
+}
+
+// TenantStatus is the status of a tenant, see Loader.SetTenantLimit.
+type TenantStatus struct {
+	MaxProc     int    `json:"maxProc"`
+	Proc        int    `json:"proc"`
+	Waiting     int    `json:"waiting"`
+	MaxInflight int    `json:"maxInflight"`
+	Inflight    int    `json:"inflight"`
+	Rejected    uint64 `json:"rejected"`
+}
+
+func (t *tenant) status() TenantStatus {
+	s := TenantStatus{
+		MaxInflight: t.maxInflight,
+		Inflight:    t.inflight,
+		Rejected:    t.rejected,
+	}
+	if t.limit != nil {
+		ls := t.limit.Stats()
+		s.MaxProc, s.Proc, s.Waiting = ls.Max, ls.InUse, ls.Waiting
+	}
+	return s
+}
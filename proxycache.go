@@ -1,22 +1,88 @@
-//package proxycache is a key-value caching library.
+// package proxycache is a key-value caching library.
+//
+// ProxyCache, also named Cache, wires everything together behind
+// Get/Put/Delete: an LRU cache.Cache for reading, a Loader loading missed keys
+// from database, a Buffer holding written data, and a Saver saving them back
+// to database asynchronously.
+// Typical usage only needs to implement the proxy.Proxy interface:
+//
+//	p := proxycache.New(db, maxEntry, saverProc, loaderProc)
+//	p.Put("key", []byte("value"), 0) // cached, and saved by db.Save
+//	v := p.Get("key")                // loaded by db.Load if not cached
+//	p.Delete("key", 0)               // saved by db.Delete
+//
+// The building blocks live in package cache and proxy, for those need to
+// assemble them differently.
 package proxycache
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/huangml/proxycache/bus"
 	"github.com/huangml/proxycache/cache"
+	"github.com/huangml/proxycache/clock"
 	"github.com/huangml/proxycache/proxy"
 )
 
+// NoSave can be used as ttw, to keep data in cache only, without saving it by
+// calling Proxy's Save method.
+const NoSave = math.MinInt64
+
+var (
+	// ErrNotInteger is returned by IncrBy if data is not an integer.
+	ErrNotInteger = errors.New("proxycache: data is not an integer")
+
+	// ErrOverflow is returned by IncrBy if the new integer overflows int64.
+	ErrOverflow = errors.New("proxycache: integer overflow")
+
+	// ErrDeleteUnsupported is returned by Delete if Proxy is not a
+	// proxy.ProxyDeleter.
+	ErrDeleteUnsupported = errors.New("proxycache: proxy does not support delete")
+
+	// ErrMaintenance is returned by writing methods in maintenance mode, see
+	// SetMaintenance.
+	ErrMaintenance = errors.New("proxycache: in maintenance mode")
+
+	// ErrBufferFull is returned by writing methods if Buffer is over its
+	// high-water mark, see SetHighWater.
+	ErrBufferFull = cache.ErrBufferFull
+)
+
 // ProxyCache is an in-memory key-value cache, and a database access proxy.
 type ProxyCache struct {
+	proxy  proxy.Proxy
 	cache  *cache.Cache
 	buffer *cache.Buffer
 	saver  *proxy.Saver
 	loader *proxy.Loader
+
+	maintMtx     sync.Mutex
+	maintenance  bool
+	serveExpired bool
+
+	serveMtx        sync.Mutex
+	memcacheMaxItem int // see SetMemcacheMaxItem
+
+	busMtx      sync.Mutex
+	bus         bus.Bus
+	origin      string
+	unsubscribe func()
 }
 
+// Cache is ProxyCache, for those expect the read-through, write-through cache
+// of package proxycache by this name.
+type Cache = ProxyCache
+
 // New creates a ProxyCache.
 func New(p proxy.Proxy, maxEntry, saverProc, loaderProc int) *ProxyCache {
 	c := cache.NewCache(maxEntry)
@@ -25,6 +91,7 @@ func New(p proxy.Proxy, maxEntry, saverProc, loaderProc int) *ProxyCache {
 	l := proxy.NewLoader(p, loaderProc)
 
 	return &ProxyCache{
+		proxy:  p,
 		cache:  c,
 		buffer: b,
 		saver:  s,
@@ -36,29 +103,530 @@ func New(p proxy.Proxy, maxEntry, saverProc, loaderProc int) *ProxyCache {
 // If provided key is not found in cache, data will be loaded by calling Proxy's
 // Load method.
 func (p *ProxyCache) Get(key string) []byte {
-	entry := p.cache.Get(key)
-	if entry != nil {
+	if entry, _, _ := p.get(key, true); entry != nil {
 		return entry.Value
 	}
+	return nil
+}
+
+// GetContext retrieves data like Get, and returns ctx.Err() if ctx is done
+// before data is loaded. The loading keeps running and caches data for later
+// requests, unless disabled by SetDetachLoads.
+func (p *ProxyCache) GetContext(ctx context.Context, key string) ([]byte, error) {
+	entry, _, _, err := p.getContext(ctx, key, true)
+	if entry != nil {
+		return entry.Value, nil
+	}
+	return nil, err
+}
+
+// GetMulti retrieves data of multiple keys like Get, in the order of keys.
+// Data not in cache are loaded together, in batches if Proxy implements
+// proxy.BatchProxyLoader, see SetLoadPartitioner.
+func (p *ProxyCache) GetMulti(keys []string) [][]byte {
+	values := make([][]byte, len(keys))
+
+	var missed []string
+	var indexes []int
+	for i, key := range keys {
+		if entry, refresh := p.cache.GetRefresh(key); entry != nil {
+			if refresh {
+				go p.refresh(key, entry.Version)
+			}
+			values[i] = entry.Value
+			continue
+		}
+		missed = append(missed, key)
+		indexes = append(indexes, i)
+	}
+	if len(missed) == 0 {
+		return values
+	}
+	if on, _ := p.inMaintenance(); on || len(missed) == 1 {
+		// no batch to load, retrieve them one by one.
+		for n, key := range missed {
+			values[indexes[n]] = p.Get(key)
+		}
+		return values
+	}
+
+	var toLoad []string
+	var loadIndexes []int
+	for n, key := range missed {
+		// entry waiting to be saved is the newest, put it back to cache.
+		if entry := p.buffer.Get(key); entry != nil {
+			if !entry.Deleted {
+				if added := p.add(key, entry.Value); added != nil {
+					values[indexes[n]] = added.Value
+				}
+			}
+			continue
+		}
+		toLoad = append(toLoad, key)
+		loadIndexes = append(loadIndexes, indexes[n])
+	}
+
+	loaded, oks := p.loader.LoadMultiAndFill(toLoad, func(key string, value []byte, ok bool) {
+		if ok && p.cache.Admit(key) {
+			p.add(key, value)
+		}
+	})
+	for n, key := range toLoad {
+		if oks[n] {
+			values[loadIndexes[n]] = loaded[n]
+		} else if entry, _ := p.cache.GetStale(key); entry != nil {
+			values[loadIndexes[n]] = entry.Value
+		}
+	}
+	return values
+}
+
+// Result describes data retrieved by Fetch.
+type Result struct {
+	// Found tells whether data is found.
+	Found bool
+	// Stale tells whether data is expired, and served because loading failed.
+	Stale bool
+	// Staleness is how long stale data has expired.
+	Staleness time.Duration
+}
+
+// Fetch retrieves data like Get, along with where it comes from.
+func (p *ProxyCache) Fetch(key string) ([]byte, Result) {
+	entry, stale, staleness := p.get(key, true)
+	if entry == nil {
+		return nil, Result{}
+	}
+	return entry.Value, Result{
+		Found:     true,
+		Stale:     stale,
+		Staleness: staleness,
+	}
+}
+
+// GetVersion retrieves data like Get, along with its version which can be used
+// by CompareAndSwap. Version 0 is returned if data is not found or not cached.
+func (p *ProxyCache) GetVersion(key string) ([]byte, uint64) {
+	if entry, _, _ := p.get(key, true); entry != nil {
+		return entry.Value, entry.Version
+	}
+	return nil, 0
+}
+
+// SetServeStale enables serving stale data: when data is expired, and loading
+// it fails, the expired data is served if it has expired for less than
+// maxStale. Proxy's Load returning !ok is treated as failure.
+// If maxStale is 0, expired data is never served.
+func (p *ProxyCache) SetServeStale(maxStale time.Duration) {
+	p.cache.SetMaxStale(maxStale)
+}
+
+// GetLease retrieves data from ProxyCache without loading it.
+// If ok is true, value is the data, or nil if the data is deleted.
+// If data is not in cache, a lease token is handed out, for the caller to load
+// data by itself and put it back by SetLease. If neither ok nor token is
+// given, the data is being leased by others, the caller should try again
+// later.
+func (p *ProxyCache) GetLease(key string) (value []byte, token uint64, ok bool) {
+	entry, token := p.cache.Lease(key)
+	if entry != nil {
+		return entry.Value, 0, true
+	}
+
+	// entry waiting to be saved is the newest, no need to load.
+	if entry = p.buffer.Get(key); entry != nil {
+		p.cache.Unlease(key, token)
+		return entry.Value, 0, true
+	}
+	return nil, token, false
+}
+
+// SetLease puts data loaded by the lease holder into cache, it will not be
+// saved. It returns false if the lease has been voided by writing or deleting
+// the data since it was handed out.
+func (p *ProxyCache) SetLease(key string, value []byte, token uint64) bool {
+	return p.cache.PutLeased(&cache.Entry{Key: key, Value: value}, token)
+}
 
+// get retrieves an entry, and how long it has expired if it is stale.
+// Stale entry is only returned if stale is true.
+func (p *ProxyCache) get(key string, stale bool) (entry *cache.Entry, isStale bool, staleness time.Duration) {
+	entry, isStale, staleness, _ = p.getContext(context.Background(), key, stale)
+	return entry, isStale, staleness
+}
+
+// getContext retrieves an entry like get, and returns ctx.Err() if ctx is
+// done before loading finishes.
+func (p *ProxyCache) getContext(ctx context.Context, key string, stale bool) (entry *cache.Entry, isStale bool, staleness time.Duration, err error) {
+	entry, refresh := p.cache.GetRefresh(key)
+	if entry != nil {
+		if refresh {
+			go p.refresh(key, entry.Version)
+		}
+		return entry, false, 0, nil
+	}
+
+	// entry waiting to be saved is the newest, put it back to cache.
 	entry = p.buffer.Get(key)
 	if entry != nil {
-		return entry.Value
+		if entry.Deleted {
+			return nil, false, 0, nil
+		}
+		return p.add(key, entry.Value), false, 0, nil
 	}
 
-	val, ok := p.loader.Load(key)
-	if ok {
-		p.cache.Put(&cache.Entry{key, val})
+	if on, serveExpired := p.inMaintenance(); on {
+		if stale && serveExpired {
+			entry, staleness = p.cache.GetStale(key)
+			return entry, entry != nil, staleness, nil
+		}
+		return nil, false, 0, nil
+	}
+
+	var filled *cache.Entry
+	val, ok, err := p.loader.LoadAndFillContext(ctx, key, func(value []byte, ok bool) {
+		if ok && p.cache.Admit(key) {
+			filled = p.add(key, value)
+		}
+	})
+	if err != nil {
+		return nil, false, 0, err
+	}
+	entry = filled
+	if !ok {
+		if stale {
+			entry, staleness = p.cache.GetStale(key)
+			return entry, entry != nil, staleness, nil
+		}
+		return nil, false, 0, nil
+	}
+	if entry != nil {
+		return entry, false, 0, nil
 	}
-	return val
+	if entry = p.cache.Get(key); entry != nil {
+		return entry, false, 0, nil
+	}
+	return &cache.Entry{Key: key, Value: val}, false, 0, nil
+}
+
+// add puts data loaded from Buffer or database back to cache, unless the key
+// is deleted meanwhile, which is told by the deletion waiting in Buffer.
+// It returns nil if the key is deleted.
+func (p *ProxyCache) add(key string, value []byte) *cache.Entry {
+	return p.cache.AddUnless(&cache.Entry{Key: key, Value: value}, func() bool {
+		e := p.buffer.Get(key)
+		return e != nil && e.Deleted
+	})
+}
+
+// refresh loads data of a cached entry in background, and replaces the entry
+// only if it is not changed meanwhile.
+func (p *ProxyCache) refresh(key string, version uint64) {
+	if on, _ := p.inMaintenance(); on {
+		return
+	}
+	// entry waiting to be saved is newer than database.
+	if p.buffer.Get(key) != nil {
+		return
+	}
+	p.loader.LoadAndFill(key, func(value []byte, ok bool) {
+		if ok {
+			p.cache.CompareAndSwap(key, version, value)
+		}
+	})
+}
+
+// Set puts data into ProxyCache like Put, saving it as soon as possible.
+func (p *ProxyCache) Set(key string, value []byte) error {
+	return p.Put(key, value, 0)
 }
 
 // Put puts data into ProxyCache.
-// Data will be saved asynchronously by calling Proxy's Save method.
-func (p *ProxyCache) Put(key string, value []byte, ttw int64) {
-	entry := &cache.Entry{key, value}
+// Data will be saved asynchronously by calling Proxy's Save method, unless ttw
+// is NoSave.
+// If Buffer is over its high-water mark, Put blocks or returns ErrBufferFull,
+// see SetHighWater.
+func (p *ProxyCache) Put(key string, value []byte, ttw int64) error {
+	release, err := p.admit(ttw, key)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	entry := &cache.Entry{Key: key, Value: value}
 	p.cache.Put(entry)
-	p.buffer.Put(entry, ttw)
+	p.save(entry, ttw)
+	return nil
+}
+
+// admit reserves room in Buffer for writing keys, the room should be released
+// once the entries are put, see Buffer.Admit.
+func (p *ProxyCache) admit(ttw int64, keys ...string) (release func(), err error) {
+	if on, _ := p.inMaintenance(); on {
+		return nil, ErrMaintenance
+	}
+	if ttw == NoSave || len(keys) == 0 {
+		return func() {}, nil
+	}
+	return p.buffer.Admit(keys...)
+}
+
+func (p *ProxyCache) save(entry *cache.Entry, ttw int64) {
+	if ttw != NoSave {
+		if !entry.Deleted {
+			p.loader.ForgetMissing(entry.Key)
+		}
+		p.buffer.Put(entry, ttw)
+	}
+}
+
+// Delete removes data from ProxyCache, and forgets in-flight loads of it.
+// The deletion will be saved asynchronously by calling Proxy's Delete method,
+// unless ttw is NoSave. ErrDeleteUnsupported is returned if Proxy is not a
+// proxy.ProxyDeleter.
+func (p *ProxyCache) Delete(key string, ttw int64) error {
+	if _, ok := p.proxy.(proxy.ProxyDeleter); !ok && ttw != NoSave {
+		return ErrDeleteUnsupported
+	}
+	release, err := p.admit(ttw, key)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	p.loader.Forget(key)
+	if ttw == NoSave {
+		p.cache.Delete(key)
+		return nil
+	}
+	// the deletion is put into Buffer with Cache locked, so loads racing it
+	// find it there, rather than putting the deleted data back to cache.
+	p.cache.DeleteFunc(key, func(deletion *cache.Entry) {
+		p.buffer.Put(deletion, ttw)
+	})
+	return nil
+}
+
+// CompareAndSwap puts data into ProxyCache like Put, only if the version of
+// cached data equals to the provided version.
+// It returns false if the data is changed or not in cache, in which case the
+// caller should get the data and try again.
+func (p *ProxyCache) CompareAndSwap(key string, version uint64, value []byte, ttw int64) (bool, error) {
+	release, err := p.admit(ttw, key)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	entry, ok := p.cache.CompareAndSwap(key, version, value)
+	if ok {
+		p.save(entry, ttw)
+	}
+	return ok, nil
+}
+
+// Update changes data atomically by calling f, and puts the new data into
+// ProxyCache like Put.
+// If data is not in cache, it will be loaded before calling f. f is called
+// with the current data and whether it exists, it returns the new data and
+// whether to keep it. If keep is false, nothing will be changed.
+// It returns whether the new data is kept.
+// Data loaded but not kept in cache, e.g. rejected by the doorkeeper or a
+// quota, is changed atomically as well, it is loaded again if saved by others
+// meanwhile, so f may be called more than once.
+// f is called with Cache locked, so it must not call ProxyCache's methods.
+// f should not modify old in place.
+func (p *ProxyCache) Update(key string, f func(old []byte, exists bool) (value []byte, keep bool), ttw int64) (kept bool, err error) {
+	release, err := p.admit(ttw, key)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	for {
+		since := p.cache.Version()
+		loaded, _, _ := p.get(key, false)
+
+		// with Cache locked, entry waiting in Buffer is the newest, otherwise
+		// loaded data is, unless saved by others after loading.
+		changed := false
+		seed := func() ([]byte, bool) {
+			if e := p.buffer.Get(key); e != nil {
+				return e.Value, !e.Deleted
+			}
+			if v, ok := p.buffer.Saved(key); ok && v > since {
+				changed = true
+				return nil, false
+			}
+			if loaded == nil {
+				return nil, false
+			}
+			return loaded.Value, true
+		}
+		var put func(entry *cache.Entry)
+		if ttw != NoSave {
+			put = func(entry *cache.Entry) {
+				p.buffer.Put(entry, ttw)
+			}
+		}
+
+		entry, ok := p.cache.UpdateFunc(key, seed, func(old []byte, exists bool) ([]byte, bool) {
+			if changed {
+				return nil, false
+			}
+			return f(old, exists)
+		}, put)
+		if changed {
+			continue
+		}
+		if ok && ttw != NoSave {
+			p.loader.ForgetMissing(entry.Key)
+		}
+		return ok, nil
+	}
+}
+
+// IncrBy interprets data as a decimal integer and adds delta to it atomically.
+// Data not exists is treated as 0.
+// It returns the new integer, or ErrNotInteger if data is not an integer, or
+// ErrOverflow if the new integer overflows, in which case data is unchanged.
+func (p *ProxyCache) IncrBy(key string, delta int64, ttw int64) (int64, error) {
+	var n int64
+	var err error
+	_, uerr := p.Update(key, func(old []byte, exists bool) ([]byte, bool) {
+		n, err = 0, nil
+		if exists {
+			if n, err = strconv.ParseInt(string(old), 10, 64); err != nil {
+				err = ErrNotInteger
+				return nil, false
+			}
+		}
+		if delta > 0 && n > math.MaxInt64-delta || delta < 0 && n < math.MinInt64-delta {
+			err = ErrOverflow
+			return nil, false
+		}
+		n += delta
+		return []byte(strconv.FormatInt(n, 10)), true
+	}, ttw)
+	if uerr != nil {
+		return 0, uerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Append appends data to the end of cached data atomically, and puts it into
+// ProxyCache like Put. Data not exists is treated as empty.
+func (p *ProxyCache) Append(key string, data []byte, ttw int64) error {
+	_, err := p.Update(key, func(old []byte, exists bool) ([]byte, bool) {
+		value := make([]byte, 0, len(old)+len(data))
+		return append(append(value, old...), data...), true
+	}, ttw)
+	return err
+}
+
+// Touch extends the expiration of a cached entry to d from now, without
+// reloading it. It returns false if the key is not in cache.
+func (p *ProxyCache) Touch(key string, d time.Duration) bool {
+	return p.cache.Touch(key, d)
+}
+
+// Clear removes all entries from cache. Entries waiting to be saved are kept
+// until saved.
+// If forgetLoads is true, in-flight loads are forgotten, so values loaded before
+// clearing will not be put back to cache.
+func (p *ProxyCache) Clear(forgetLoads bool) {
+	if forgetLoads {
+		p.loader.ForgetAll()
+	}
+	p.cache.Clear()
+}
+
+// SetOnEvict sets a handler which is called when an entry is evicted, expired
+// or cleared from cache.
+// f may be called while a load is finishing, so it must not call ProxyCache's
+// methods.
+func (p *ProxyCache) SetOnEvict(f func(key string, value []byte)) {
+	if f == nil {
+		p.cache.SetOnEvict(nil)
+		return
+	}
+	p.cache.SetOnEvict(func(entry *cache.Entry) {
+		f(entry.Key, entry.Value)
+	})
+}
+
+// SetClock sets the clock of all time-based behaviors, so they can be tested
+// by a fake clock, see package clock. It should be called before other setters
+// which start timers, like SetFlushInterval.
+func (p *ProxyCache) SetClock(clk clock.Clock) {
+	p.cache.SetClock(clk)
+	p.buffer.SetClock(clk)
+	p.loader.SetClock(clk)
+	p.saver.SetClock(clk)
+}
+
+// SetTTL sets Cache's ttl. Expired entries will be loaded again by calling
+// Proxy's Load method.
+func (p *ProxyCache) SetTTL(ttl time.Duration) {
+	p.cache.SetTTL(ttl)
+}
+
+// SetDedup enables Cache to store identical values once, for values at least
+// minSize bytes, see cache.Cache.SetDedup.
+func (p *ProxyCache) SetDedup(minSize int) {
+	p.cache.SetDedup(minSize)
+}
+
+// SetNamespace sets the function telling which namespace a key belongs to, for
+// quotas, see SetQuota.
+func (p *ProxyCache) SetNamespace(f func(key string) string) {
+	p.cache.SetNamespace(f)
+}
+
+// SetQuota limits cached entries of a namespace, see cache.Cache.SetQuota.
+func (p *ProxyCache) SetQuota(namespace string, maxEntry int, maxBytes int64) {
+	p.cache.SetQuota(namespace, maxEntry, maxBytes)
+}
+
+// SetMemoryLimit enables Cache to evict cold entries when heap usage is over
+// limit, see cache.Cache.SetMemoryLimit.
+func (p *ProxyCache) SetMemoryLimit(limit uint64, interval time.Duration, fraction float64) {
+	p.cache.SetMemoryLimit(limit, interval, fraction)
+}
+
+// Shrink evicts the least-recently-used fraction of cached entries, see
+// cache.Cache.Shrink.
+func (p *ProxyCache) Shrink(fraction float64) int {
+	return p.cache.Shrink(fraction)
+}
+
+// SetExpiration sets how Cache removes expired entries, see
+// cache.Cache.SetExpiration.
+func (p *ProxyCache) SetExpiration(mode cache.Expiration, interval time.Duration, budget int) {
+	p.cache.SetExpiration(mode, interval, budget)
+}
+
+// SetExpiryIndex sets the index Cache uses to remove expired entries, see
+// cache.Cache.SetExpiryIndex.
+func (p *ProxyCache) SetExpiryIndex(kind cache.ExpiryIndex, tick time.Duration) {
+	p.cache.SetExpiryIndex(kind, tick)
+}
+
+// SetSoftTTL sets Cache's soft ttl. Data older than the soft ttl is still
+// served, and loaded again in background to refresh it. Data older than the
+// ttl set by SetTTL, the hard one, is never served, unless serving stale.
+// If soft is 0, data is never refreshed.
+func (p *ProxyCache) SetSoftTTL(soft time.Duration) {
+	p.cache.SetSoftTTL(soft)
+}
+
+// SetDoorkeeper enables Cache's doorkeeper, so loaded data is only cached on
+// its second access in a window, see cache.Cache.SetDoorkeeper.
+func (p *ProxyCache) SetDoorkeeper(n int, fp float64, window time.Duration) {
+	p.cache.SetDoorkeeper(n, fp, window)
 }
 
 // SetMaxEntry sets Cache's maxEntry.
@@ -71,11 +639,141 @@ func (p *ProxyCache) SetLoadMaxProc(maxProc int) {
 	p.loader.SetMaxProc(maxProc)
 }
 
+// SetLoadBandwidth limits the bytes per second loaded from database, see
+// proxy.Loader.SetBandwidth.
+func (p *ProxyCache) SetLoadBandwidth(bytesPerSec, burst int) {
+	p.loader.SetBandwidth(bytesPerSec, burst)
+}
+
+// SetLoadTenant sets the function telling which tenant a key belongs to, see
+// proxy.Loader.SetTenant.
+func (p *ProxyCache) SetLoadTenant(f func(key string) string) {
+	p.loader.SetTenant(f)
+}
+
+// SetLoadTenantLimit limits loads of a tenant, see
+// proxy.Loader.SetTenantLimit. Rejected loads are served like missing keys.
+func (p *ProxyCache) SetLoadTenantLimit(tenant string, maxProc, maxInflight int) {
+	p.loader.SetTenantLimit(tenant, maxProc, maxInflight)
+}
+
+// SetDefaultLoadTenantLimit limits loads of tenants without their own limit,
+// see proxy.Loader.SetDefaultTenantLimit.
+func (p *ProxyCache) SetDefaultLoadTenantLimit(maxProc, maxInflight int) {
+	p.loader.SetDefaultTenantLimit(maxProc, maxInflight)
+}
+
+// SetDetachLoads sets whether loads keep running after callers of GetContext
+// give up, see proxy.Loader.SetDetach.
+func (p *ProxyCache) SetDetachLoads(detach bool) {
+	p.loader.SetDetach(detach)
+}
+
+// SetLoadPartitioner sets the function telling which backend shard a key
+// belongs to, so GetMulti loads one batch per shard, see
+// proxy.Loader.SetPartitioner.
+func (p *ProxyCache) SetLoadPartitioner(f func(key string) int) {
+	p.loader.SetPartitioner(f)
+}
+
+// SetLoadMaxBatch sets the maximum number of keys loaded in one batch, see
+// proxy.Loader.SetMaxLoadBatch.
+func (p *ProxyCache) SetLoadMaxBatch(maxBatch int) {
+	p.loader.SetMaxLoadBatch(maxBatch)
+}
+
+// SetLoadLabels sets pprof labels of goroutines loading from database, see
+// proxy.Loader.SetLabels.
+func (p *ProxyCache) SetLoadLabels(name string, namespace func(key string) string) {
+	p.loader.SetLabels(name, namespace)
+}
+
+// SetMissFilter enables Loader's miss filter, see proxy.Loader.SetMissFilter.
+// Keys written by ProxyCache are removed from the filter automatically.
+func (p *ProxyCache) SetMissFilter(n int, fp float64, interval time.Duration) {
+	p.loader.SetMissFilter(n, fp, interval)
+}
+
 // SetSaveProc sets the number of Saver's workers.
 func (p *ProxyCache) SetSaveProc(proc int) {
 	p.saver.SetMaxProc(proc)
 }
 
+// SetFlushInterval enables Buffer's write-behind mode, see
+// cache.Buffer.SetFlushInterval.
+func (p *ProxyCache) SetFlushInterval(interval time.Duration, threshold int) {
+	p.buffer.SetFlushInterval(interval, threshold)
+}
+
+// Flush saves all data waiting in ProxyCache synchronously.
+// It returns ctx.Err() if ctx is done before all data are saved.
+func (p *ProxyCache) Flush(ctx context.Context) error {
+	return p.saver.Flush(ctx)
+}
+
+// Close flushes data like Flush, then stops saving. It should be called for
+// graceful shutdown, data put after closing will not be saved.
+func (p *ProxyCache) Close(ctx context.Context) error {
+	err := p.saver.Close(ctx)
+	p.buffer.Close()
+	return err
+}
+
+// SetHighWater sets Buffer's high-water mark. When the number of data waiting
+// to be saved reaches highWater, writing methods block until some are saved if
+// block is true, or return ErrBufferFull. If highWater is 0, there is no limit.
+func (p *ProxyCache) SetHighWater(highWater int, block bool) {
+	p.buffer.SetHighWater(highWater, block)
+}
+
+// SetSaveMaxRetry sets Buffer's maxRetry. Entries failed to save more than
+// maxRetry times are moved to dead letters.
+func (p *ProxyCache) SetSaveMaxRetry(maxRetry int) {
+	p.buffer.SetMaxRetry(maxRetry)
+}
+
+// DeadLetters returns entries failed to save too many times.
+func (p *ProxyCache) DeadLetters() []cache.DeadLetter {
+	return p.buffer.DeadLetters()
+}
+
+// RetryDeadLetter saves a dead letter again.
+func (p *ProxyCache) RetryDeadLetter(key string) bool {
+	return p.buffer.RetryDeadLetter(key)
+}
+
+// DropDeadLetter drops a dead letter without saving it.
+func (p *ProxyCache) DropDeadLetter(key string) bool {
+	return p.buffer.DropDeadLetter(key)
+}
+
+// SetSaveMaxBatch sets Saver's maxBatch.
+func (p *ProxyCache) SetSaveMaxBatch(maxBatch int) {
+	p.saver.SetMaxBatch(maxBatch)
+}
+
+// Dump writes cached entries to w for debugging, see cache.Cache.Dump.
+func (p *ProxyCache) Dump(w io.Writer, format cache.DumpFormat) error {
+	return p.cache.Dump(w, format)
+}
+
+// Meta returns the metadata of a cached entry, see cache.Cache.Meta.
+func (p *ProxyCache) Meta(key string) (cache.EntryMeta, bool) {
+	return p.cache.Meta(key)
+}
+
+// TopKeys returns the hottest and largest keys cached, see
+// cache.Cache.TopKeys.
+func (p *ProxyCache) TopKeys(n int) cache.TopKeys {
+	return p.cache.TopKeys(n)
+}
+
+// SetHotWindow sets the window of recent hits reported by TopKeys, see
+// cache.Cache.SetHotWindow.
+func (p *ProxyCache) SetHotWindow(window time.Duration) {
+	p.cache.SetHotWindow(window)
+}
+
 // HTTPHandlerV1 create HTTP handler (version 1).
 // To serve on a sub URI, don't forget to use http.StripPrefix().
 // Check example/server for more details.
@@ -89,6 +787,7 @@ type Status struct {
 	cache.BufferStatus
 	proxy.LoaderStatus
 	proxy.SaverStatus
+	MaintenanceStatus
 }
 
 // Status returns ProxyCache's runtime performance status.
@@ -99,7 +798,26 @@ func (p *ProxyCache) Status() []byte {
 		LoaderStatus: p.loader.Status(),
 		SaverStatus:  p.saver.Status(),
 	}
+	s.Maintenance, s.MaintenanceServeExpired = p.inMaintenance()
 
 	b, _ := json.Marshal(s)
 	return b
 }
+
+type statusField struct {
+	name, value string
+}
+
+// statusFields returns fields of Status sorted by names, for text protocols.
+// Fields not of scalar values are in JSON.
+func (p *ProxyCache) statusFields() []statusField {
+	var m map[string]json.RawMessage
+	json.Unmarshal(p.Status(), &m)
+
+	fields := make([]statusField, 0, len(m))
+	for name, value := range m {
+		fields = append(fields, statusField{name, strings.Trim(string(value), `"`)})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	return fields
+}
@@ -0,0 +1,247 @@
+package proxycache
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huangml/proxycache/proxytest"
+)
+
+// store is a Proxy saving into the values of a proxytest.Loader.
+type store struct {
+	*proxytest.Loader
+}
+
+func (s store) Save(key string, value []byte) bool {
+	s.Set(key, value)
+	return true
+}
+
+func (s store) Delete(key string) bool {
+	s.Loader.Delete(key)
+	return true
+}
+
+func newTestProxyCache(t *testing.T) (*ProxyCache, *proxytest.Loader) {
+	l := proxytest.NewLoader()
+	p := New(store{l}, 100, 1, 1)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		p.Close(ctx)
+	})
+	return p, l
+}
+
+func flush(t *testing.T, p *ProxyCache) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Flush(ctx); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+}
+
+func TestCloseStopsGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		p := New(store{proxytest.NewLoader()}, 100, 2, 2)
+		p.SetFlushInterval(time.Hour, 0)
+		if err := p.Put("k", []byte("v"), 0); err != nil {
+			t.Fatalf("Put() = %v", err)
+		}
+		if err := p.Close(context.Background()); err != nil {
+			t.Fatalf("Close() = %v", err)
+		}
+	}
+
+	for start := time.Now(); runtime.NumGoroutine() > before; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("%d goroutines left running after closed", runtime.NumGoroutine()-before)
+		}
+	}
+}
+
+func TestCacheSet(t *testing.T) {
+	l := proxytest.NewLoader()
+	var c *Cache = New(store{l}, 100, 1, 1)
+	defer c.Close(context.Background())
+
+	if err := c.Set("k", []byte("v")); err != nil {
+		t.Fatalf("Set() = %v", err)
+	}
+	if v := c.Get("k"); string(v) != "v" {
+		t.Errorf("Get() = %q after Set, want %q", v, "v")
+	}
+	flush(t, c)
+	if v, _ := l.Load("k"); string(v) != "v" {
+		t.Errorf("saved %q, want %q", v, "v")
+	}
+
+	if err := c.Delete("k", 0); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	flush(t, c)
+	if v := c.Get("k"); v != nil {
+		t.Errorf("Get() = %q after Delete", v)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(p *ProxyCache)
+		value  string // in database, empty if missing
+		loads  int
+		want   string
+		cached bool
+	}{
+		{"cached", nil, "v", 1, "v!", true},
+		{"missing", nil, "", 1, "!", true},
+		{"rejected by doorkeeper", func(p *ProxyCache) {
+			p.SetDoorkeeper(100, 0.01, time.Hour)
+		}, "v", 1, "v!", true},
+		{"rejected by quota", func(p *ProxyCache) {
+			p.SetNamespace(func(string) string { return "ns" })
+			p.SetQuota("ns", 0, 1)
+		}, "v", 1, "v!", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, l := newTestProxyCache(t)
+			if tt.setup != nil {
+				tt.setup(p)
+			}
+			if tt.value != "" {
+				l.Set("k", []byte(tt.value))
+			}
+
+			kept, err := p.Update("k", func(old []byte, exists bool) ([]byte, bool) {
+				return append(append([]byte(nil), old...), '!'), true
+			}, 0)
+			if !kept || err != nil {
+				t.Fatalf("Update() = %v, %v", kept, err)
+			}
+			l.AssertLoads(t, "k", tt.loads)
+
+			if _, cached := p.Meta("k"); cached != tt.cached {
+				t.Errorf("cached = %v, want %v", cached, tt.cached)
+			}
+			flush(t, p)
+			if v, _ := l.Load("k"); string(v) != tt.want {
+				t.Errorf("saved %q, want %q", v, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncrBy(t *testing.T) {
+	max, min := strconv.FormatInt(math.MaxInt64, 10), strconv.FormatInt(math.MinInt64, 10)
+	tests := []struct {
+		value string // empty if missing
+		delta int64
+		want  int64
+		err   error
+		saved string
+	}{
+		{"", 2, 2, nil, "2"},
+		{"1", -3, -2, nil, "-2"},
+		{"x", 1, 0, ErrNotInteger, "x"},
+		{strconv.FormatInt(math.MaxInt64-1, 10), 1, math.MaxInt64, nil, max},
+		{max, 1, 0, ErrOverflow, max},
+		{"1", math.MaxInt64, 0, ErrOverflow, "1"},
+		{min, -1, 0, ErrOverflow, min},
+		{"-2", math.MinInt64, 0, ErrOverflow, "-2"},
+		{min, math.MaxInt64, -1, nil, "-1"},
+	}
+
+	for _, tt := range tests {
+		p, l := newTestProxyCache(t)
+		if tt.value != "" {
+			l.Set("k", []byte(tt.value))
+		}
+
+		n, err := p.IncrBy("k", tt.delta, 0)
+		if n != tt.want || err != tt.err {
+			t.Errorf("IncrBy(%q, %d) = %d, %v, want %d, %v", tt.value, tt.delta, n, err, tt.want, tt.err)
+		}
+		flush(t, p)
+		if v, _ := l.Load("k"); string(v) != tt.saved {
+			t.Errorf("IncrBy(%q, %d) saved %q, want %q", tt.value, tt.delta, v, tt.saved)
+		}
+	}
+}
+
+func TestIncrByNotCached(t *testing.T) {
+	p, l := newTestProxyCache(t)
+	p.SetNamespace(func(string) string { return "ns" })
+	p.SetQuota("ns", 0, 1)              // nothing is cached
+	l.SetLatency("k", time.Millisecond) // increments race loads and saves
+
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.IncrBy("k", 1, 0); err != nil {
+				t.Errorf("IncrBy() = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	flush(t, p)
+	if v, _ := l.Load("k"); string(v) != strconv.Itoa(n) {
+		t.Errorf("saved %q after %d increments", v, n)
+	}
+}
+
+func TestUpdateNotKept(t *testing.T) {
+	p, l := newTestProxyCache(t)
+	l.Set("k", []byte("v"))
+
+	kept, err := p.Update("k", func(old []byte, exists bool) ([]byte, bool) {
+		return nil, false
+	}, 0)
+	if kept || err != nil {
+		t.Fatalf("Update() = %v, %v, want false, nil", kept, err)
+	}
+	if v := p.Get("k"); string(v) != "v" {
+		t.Errorf("Get() = %q after Update not kept, want %q", v, "v")
+	}
+}
+
+func TestTxnHighWater(t *testing.T) {
+	p, _ := newTestProxyCache(t)
+	p.SetHighWater(2, false)
+	p.buffer.SetPaused(true) // keep entries pending
+	defer p.buffer.SetPaused(false)
+
+	put := func(keys ...string) error {
+		return p.Txn(func(tx *Tx) error {
+			for _, key := range keys {
+				tx.Put(key, []byte("v"))
+			}
+			return nil
+		}, 0)
+	}
+	if err := put("a", "b"); err != nil {
+		t.Fatalf("Txn() of 2 keys = %v", err)
+	}
+	if err := put("a", "c"); err != ErrBufferFull {
+		t.Fatalf("Txn() over the high-water mark = %v, want %v", err, ErrBufferFull)
+	}
+	if v := p.Get("c"); v != nil {
+		t.Errorf("Get() = %q of a Txn rejected", v)
+	}
+	if err := put("a", "b"); err != nil {
+		t.Fatalf("Txn() of pending keys = %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package proxytest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/huangml/proxycache/proxy"
+)
+
+// Barrier is a ProxyLoader which blocks every Load until it is released, so
+// tests can decide the order loads finish in.
+type Barrier struct {
+	p proxy.ProxyLoader
+
+	mtx     sync.Mutex
+	cond    *sync.Cond
+	blocked []*blockedLoad
+}
+
+type blockedLoad struct {
+	key     string
+	release chan struct{}
+}
+
+// NewBarrier creates a Barrier in front of p.
+func NewBarrier(p proxy.ProxyLoader) *Barrier {
+	b := &Barrier{p: p}
+	b.cond = sync.NewCond(&b.mtx)
+	return b
+}
+
+// Load implements ProxyLoader. It blocks until released, then calls the
+// underlying ProxyLoader.
+func (b *Barrier) Load(key string) (value []byte, ok bool) {
+	return b.LoadContext(context.Background(), key)
+}
+
+// LoadContext implements ContextProxyLoader. It blocks until released, then
+// calls the underlying ProxyLoader with ctx. If ctx is done first, the load
+// leaves the barrier and fails.
+func (b *Barrier) LoadContext(ctx context.Context, key string) (value []byte, ok bool) {
+	l := &blockedLoad{key: key, release: make(chan struct{})}
+
+	b.mtx.Lock()
+	b.blocked = append(b.blocked, l)
+	b.cond.Broadcast()
+	b.mtx.Unlock()
+
+	select {
+	case <-l.release:
+	case <-ctx.Done():
+		b.mtx.Lock()
+		defer b.mtx.Unlock()
+
+		for i, bl := range b.blocked {
+			if bl == l {
+				b.blocked = append(b.blocked[:i], b.blocked[i+1:]...)
+				return nil, false
+			}
+		}
+		// released meanwhile.
+	}
+	return proxy.LoadContext(ctx, b.p, key)
+}
+
+// Wait blocks until at least n loads are blocked at the barrier.
+func (b *Barrier) Wait(n int) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	for len(b.blocked) < n {
+		b.cond.Wait()
+	}
+}
+
+// Blocked returns keys of loads blocked at the barrier, in the order they
+// arrived.
+func (b *Barrier) Blocked() []string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	keys := make([]string, len(b.blocked))
+	for i, l := range b.blocked {
+		keys[i] = l.key
+	}
+	return keys
+}
+
+// Release releases the earliest blocked load of a key.
+// It returns false if no load of the key is blocked.
+func (b *Barrier) Release(key string) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	for i, l := range b.blocked {
+		if l.key == key {
+			b.blocked = append(b.blocked[:i], b.blocked[i+1:]...)
+			close(l.release)
+			return true
+		}
+	}
+	return false
+}
+
+// ReleaseAll releases all blocked loads.
+func (b *Barrier) ReleaseAll() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	for _, l := range b.blocked {
+		close(l.release)
+	}
+	b.blocked = nil
+}
@@ -0,0 +1,168 @@
+// package proxytest provides utilities for testing code using proxycache.
+package proxytest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huangml/proxycache/clock"
+)
+
+// Loader is a scriptable ProxyLoader for tests.
+// It loads values set by Set, fails keys set by Fail, and reports other keys
+// not found. Every call is recorded, see Calls and AssertLoads.
+type Loader struct {
+	mtx       sync.Mutex
+	values    map[string][]byte
+	failures  map[string]bool
+	latencies map[string]time.Duration
+	latency   time.Duration // for keys without their own latency
+	clock     clock.Clock
+	calls     []string
+	counts    map[string]int
+}
+
+// NewLoader creates a Loader with no values.
+func NewLoader() *Loader {
+	return &Loader{
+		values:    make(map[string][]byte),
+		failures:  make(map[string]bool),
+		latencies: make(map[string]time.Duration),
+		clock:     clock.Real,
+		counts:    make(map[string]int),
+	}
+}
+
+// Set sets the value loaded for a key, and clears its failure.
+func (l *Loader) Set(key string, value []byte) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.values[key] = value
+	delete(l.failures, key)
+}
+
+// Fail makes loading a key fail, which is reported as not ok like a missing
+// key, until the key is Set.
+func (l *Loader) Fail(key string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.failures[key] = true
+}
+
+// Delete makes a key missing.
+func (l *Loader) Delete(key string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	delete(l.values, key)
+	delete(l.failures, key)
+}
+
+// SetLatency sets how long loading a key takes. If key is empty, it sets the
+// latency of keys without their own.
+func (l *Loader) SetLatency(key string, d time.Duration) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if key == "" {
+		l.latency = d
+	} else {
+		l.latencies[key] = d
+	}
+}
+
+// SetClock sets the clock latencies are waited by, so a fake clock can release
+// slow loads by advancing.
+func (l *Loader) SetClock(clk clock.Clock) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.clock = clk
+}
+
+// Load implements ProxyLoader.
+// The call is recorded before waiting for latency, and the result is decided
+// after.
+func (l *Loader) Load(key string) (value []byte, ok bool) {
+	return l.LoadContext(context.Background(), key)
+}
+
+// LoadContext implements proxy.ContextProxyLoader. It fails if ctx is done
+// while waiting for latency.
+func (l *Loader) LoadContext(ctx context.Context, key string) (value []byte, ok bool) {
+	l.mtx.Lock()
+	l.calls = append(l.calls, key)
+	l.counts[key]++
+	d, found := l.latencies[key]
+	if !found {
+		d = l.latency
+	}
+	clk := l.clock
+	l.mtx.Unlock()
+
+	if d > 0 {
+		select {
+		case <-clk.After(d):
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.failures[key] {
+		return nil, false
+	}
+	value, ok = l.values[key]
+	return value, ok
+}
+
+// Calls returns keys of all Load calls, in the order they are called.
+func (l *Loader) Calls() []string {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return append([]string(nil), l.calls...)
+}
+
+// Count returns the number of Load calls of a key.
+func (l *Loader) Count(key string) int {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.counts[key]
+}
+
+// ResetCalls forgets all recorded calls.
+func (l *Loader) ResetCalls() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.calls = nil
+	l.counts = make(map[string]int)
+}
+
+// AssertLoads reports an error to t if a key is not loaded exactly n times.
+func (l *Loader) AssertLoads(t testing.TB, key string, n int) {
+	t.Helper()
+	if got := l.Count(key); got != n {
+		t.Errorf("proxytest: key %q loaded %d times, want %d", key, got, n)
+	}
+}
+
+// AssertLoadedOnce reports an error to t if a key is not loaded exactly once.
+func (l *Loader) AssertLoadedOnce(t testing.TB, key string) {
+	t.Helper()
+	l.AssertLoads(t, key, 1)
+}
+
+// AssertNotLoaded reports an error to t if a key is ever loaded.
+func (l *Loader) AssertNotLoaded(t testing.TB, key string) {
+	t.Helper()
+	l.AssertLoads(t, key, 0)
+}
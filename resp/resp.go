@@ -0,0 +1,147 @@
+// package resp implements the Redis serialization protocol.
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Error is an error reply.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// ErrProtocol is returned when reading malformed data.
+var ErrProtocol = errors.New("resp: protocol error")
+
+// Limits of values read, beyond which Read returns ErrProtocol.
+const (
+	MaxBulkLen  = 512 << 20 // bytes of a bulk string
+	MaxArrayLen = 1 << 20   // elements of an array
+	MaxDepth    = 32        // levels of nested arrays
+)
+
+// Read reads a value from r.
+// It returns a string for simple and bulk strings, an int64 for integers,
+// a []interface{} for arrays, an Error for errors, and nil for null.
+func Read(r *bufio.Reader) (interface{}, error) {
+	return read(r, 0)
+}
+
+func read(r *bufio.Reader, depth int) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, ErrProtocol
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n > MaxBulkLen {
+			return nil, ErrProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		// grow with data read, rather than trusting n.
+		var b bytes.Buffer
+		if _, err := io.CopyN(&b, r, int64(n)+2); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(b.Bytes(), []byte("\r\n")) {
+			return nil, ErrProtocol
+		}
+		return string(b.Bytes()[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n > MaxArrayLen || depth >= MaxDepth {
+			return nil, ErrProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		// grow with elements read, rather than trusting n.
+		c := n
+		if c > 1024 {
+			c = 1024
+		}
+		a := make([]interface{}, 0, c)
+		for i := 0; i < n; i++ {
+			v, err := read(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		return a, nil
+	default:
+		return nil, ErrProtocol
+	}
+}
+
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, ErrProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+// Write writes a value to w, the value can be of types returned by Read, and
+// []byte for bulk strings, []string for arrays of bulk strings.
+func Write(w *bufio.Writer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []byte:
+		fmt.Fprintf(w, "$%d\r\n", len(v))
+		w.Write(v)
+		w.WriteString("\r\n")
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case Error:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case []string:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, s := range v {
+			Write(w, s)
+		}
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, e := range v {
+			if err := Write(w, e); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("resp: unsupported type %T", v)
+	}
+	return nil
+}
+
+// WriteStatus writes a simple string to w.
+func WriteStatus(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "+%s\r\n", s)
+}
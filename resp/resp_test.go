@@ -0,0 +1,94 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  interface{}
+		err   error
+	}{
+		{"simple string", "+OK\r\n", "OK", nil},
+		{"error", "-ERR bad\r\n", Error("ERR bad"), nil},
+		{"integer", ":42\r\n", int64(42), nil},
+		{"bulk string", "$5\r\nhello\r\n", "hello", nil},
+		{"empty bulk string", "$0\r\n\r\n", "", nil},
+		{"bulk string with CRLF", "$4\r\na\r\nb\r\n", "a\r\nb", nil},
+		{"null bulk string", "$-1\r\n", nil, nil},
+		{"array", "*2\r\n$1\r\na\r\n:1\r\n", []interface{}{"a", int64(1)}, nil},
+		{"empty array", "*0\r\n", []interface{}{}, nil},
+		{"null array", "*-1\r\n", nil, nil},
+		{"nested array", "*1\r\n*1\r\n+a\r\n", []interface{}{[]interface{}{"a"}}, nil},
+
+		{"empty line", "\r\n", nil, ErrProtocol},
+		{"unknown type", "!1\r\n", nil, ErrProtocol},
+		{"missing CR", "+OK\n", nil, ErrProtocol},
+		{"bad bulk length", "$x\r\n", nil, ErrProtocol},
+		{"bulk without CRLF", "$2\r\nabcd", nil, ErrProtocol},
+		{"bulk too long", "$" + strconv.Itoa(MaxBulkLen+1) + "\r\n", nil, ErrProtocol},
+		{"bad array length", "*x\r\n", nil, ErrProtocol},
+		{"array too long", "*" + strconv.Itoa(MaxArrayLen+1) + "\r\n", nil, ErrProtocol},
+		{"array too deep", strings.Repeat("*1\r\n", MaxDepth+1) + "+a\r\n", nil, ErrProtocol},
+		{"bad element", "*1\r\n!\r\n", nil, ErrProtocol},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := Read(bufio.NewReader(strings.NewReader(tt.input)))
+			if err != tt.err {
+				t.Fatalf("Read(%q) error = %v, want %v", tt.input, err, tt.err)
+			}
+			if !reflect.DeepEqual(v, tt.want) {
+				t.Errorf("Read(%q) = %#v, want %#v", tt.input, v, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	for _, input := range []string{"+OK", "$5\r\nhel", "*2\r\n+a\r\n"} {
+		if _, err := Read(bufio.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("Read(%q) succeeded, want an error", input)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{nil, "$-1\r\n"},
+		{"a", "$1\r\na\r\n"},
+		{[]byte("ab"), "$2\r\nab\r\n"},
+		{int64(-1), ":-1\r\n"},
+		{3, ":3\r\n"},
+		{Error("ERR x"), "-ERR x\r\n"},
+		{[]string{"GET", "k"}, "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"},
+		{[]interface{}{"a", int64(1), nil}, "*3\r\n$1\r\na\r\n:1\r\n$-1\r\n"},
+	}
+
+	for _, tt := range tests {
+		var b bytes.Buffer
+		w := bufio.NewWriter(&b)
+		if err := Write(w, tt.v); err != nil {
+			t.Fatalf("Write(%#v) error = %v", tt.v, err)
+		}
+		w.Flush()
+		if b.String() != tt.want {
+			t.Errorf("Write(%#v) = %q, want %q", tt.v, b.String(), tt.want)
+		}
+	}
+
+	if err := Write(bufio.NewWriter(&bytes.Buffer{}), 1.5); err == nil {
+		t.Error("Write(1.5) succeeded, want an error")
+	}
+}
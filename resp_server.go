@@ -0,0 +1,145 @@
+package proxycache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/huangml/proxycache/resp"
+)
+
+// ServeRESP serves a subset of the Redis protocol on l, so Redis clients can
+// use ProxyCache directly. It blocks until l fails, and returns the error.
+// Supported commands are GET, SET, DEL, TTL, INFO, PING and QUIT. SET and DEL
+// save to database with ttw. TTL reports the key in cache only, it returns -2
+// if the key is not cached, and -1 if the entry never expires. INFO returns
+// Status as lines of "name:value".
+func (p *ProxyCache) ServeRESP(l net.Listener, ttw int64) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.serveRESPConn(conn, ttw)
+	}
+}
+
+func (p *ProxyCache) serveRESPConn(conn net.Conn, ttw int64) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		v, err := resp.Read(r)
+		if err != nil {
+			return
+		}
+		args, ok := respArgs(v)
+		if !ok || len(args) == 0 {
+			resp.Write(w, resp.Error("ERR protocol error"))
+			w.Flush()
+			return
+		}
+
+		quit := strings.ToUpper(args[0]) == "QUIT"
+		p.execRESP(w, args, ttw)
+		if w.Flush() != nil || quit {
+			return
+		}
+	}
+}
+
+// respArgs converts a command read to strings.
+func respArgs(v interface{}) ([]string, bool) {
+	a, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	args := make([]string, len(a))
+	for i, e := range a {
+		if args[i], ok = e.(string); !ok {
+			return nil, false
+		}
+	}
+	return args, true
+}
+
+// respArity is the number of arguments of commands, including the name.
+// A negative arity is the minimum.
+var respArity = map[string]int{
+	"GET":  2,
+	"SET":  3,
+	"DEL":  -2,
+	"TTL":  2,
+	"INFO": -1,
+	"PING": 1,
+	"QUIT": 1,
+}
+
+func (p *ProxyCache) execRESP(w *bufio.Writer, args []string, ttw int64) {
+	name := strings.ToUpper(args[0])
+	arity, ok := respArity[name]
+	if !ok {
+		resp.Write(w, resp.Error(fmt.Sprintf("ERR unknown command '%s'", args[0])))
+		return
+	}
+	if (arity > 0 && len(args) != arity) || len(args) < -arity {
+		resp.Write(w, resp.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", args[0])))
+		return
+	}
+
+	switch name {
+	case "GET":
+		if b := p.Get(args[1]); b != nil {
+			resp.Write(w, b)
+		} else {
+			resp.Write(w, nil)
+		}
+	case "SET":
+		if err := p.Put(args[1], []byte(args[2]), ttw); err != nil {
+			resp.Write(w, resp.Error("ERR "+err.Error()))
+			return
+		}
+		resp.WriteStatus(w, "OK")
+	case "DEL":
+		n := 0
+		for _, key := range args[1:] {
+			_, cached := p.Meta(key)
+			if err := p.Delete(key, ttw); err != nil {
+				resp.Write(w, resp.Error("ERR "+err.Error()))
+				return
+			}
+			if cached {
+				n++
+			}
+		}
+		resp.Write(w, n)
+	case "TTL":
+		m, ok := p.Meta(args[1])
+		switch {
+		case !ok:
+			resp.Write(w, -2)
+		case m.Expire.IsZero():
+			resp.Write(w, -1)
+		default:
+			resp.Write(w, int64(m.TTL.Seconds()))
+		}
+	case "INFO":
+		resp.Write(w, p.info())
+	case "PING":
+		resp.WriteStatus(w, "PONG")
+	case "QUIT":
+		resp.WriteStatus(w, "OK")
+	}
+}
+
+// info formats Status like Redis INFO.
+func (p *ProxyCache) info() string {
+	var b strings.Builder
+	b.WriteString("# proxycache\r\n")
+	for _, f := range p.statusFields() {
+		fmt.Fprintf(&b, "%s:%s\r\n", f.name, f.value)
+	}
+	return b.String()
+}
@@ -0,0 +1,214 @@
+// package ring implements a consistent hashing ring.
+package ring
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Ring maps keys to members by consistent hashing.
+// Each member is placed on the ring as weight * replicas virtual nodes, so
+// a member owns keys in proportion to its weight.
+type Ring struct {
+	replicas int
+
+	mtx     sync.RWMutex
+	weights map[string]int
+	points  []point // sorted by hash
+}
+
+type point struct {
+	hash   uint32
+	member string
+}
+
+// Stats describes the ring after a change.
+type Stats struct {
+	// Moved is the fraction of keys whose owner is changed.
+	Moved float64 `json:"moved"`
+	// Shares is the fraction of keys owned by each member.
+	Shares map[string]float64 `json:"shares"`
+}
+
+// New creates a Ring, which puts replicas virtual nodes per unit of weight.
+func New(replicas int) *Ring {
+	if replicas < 1 {
+		replicas = 1
+	}
+	return &Ring{
+		replicas: replicas,
+		weights:  make(map[string]int),
+	}
+}
+
+// Set adds a member, or changes its weight. A member of weight 0 is removed.
+// It returns the rebalancing stats of the change.
+func (r *Ring) Set(member string, weight int) Stats {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if weight > 0 {
+		r.weights[member] = weight
+	} else {
+		delete(r.weights, member)
+	}
+
+	old := r.points
+	r.points = r.build()
+	return Stats{
+		Moved:  moved(old, r.points),
+		Shares: shares(r.points),
+	}
+}
+
+// Remove removes a member. It returns the rebalancing stats of the change.
+func (r *Ring) Remove(member string) Stats {
+	return r.Set(member, 0)
+}
+
+func (r *Ring) build() []point {
+	var points []point
+	for member, weight := range r.weights {
+		for i := 0; i < weight*r.replicas; i++ {
+			h := hash(member + "#" + strconv.Itoa(i))
+			points = append(points, point{h, member})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].member < points[j].member
+	})
+	return points
+}
+
+// Get returns the owner of key. It returns "" if the ring is empty.
+func (r *Ring) Get(key string) string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	if len(r.points) == 0 {
+		return ""
+	}
+	return r.points[search(r.points, hash(key))].member
+}
+
+// GetN returns up to n distinct members for key, starting from its owner and
+// walking clockwise on the ring.
+func (r *Ring) GetN(key string, n int) []string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	if n > len(r.weights) {
+		n = len(r.weights)
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	members := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	i := search(r.points, hash(key))
+	for len(members) < n {
+		if m := r.points[i].member; !seen[m] {
+			seen[m] = true
+			members = append(members, m)
+		}
+		i = (i + 1) % len(r.points)
+	}
+	return members
+}
+
+// Members returns all members with their weights.
+func (r *Ring) Members() map[string]int {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	m := make(map[string]int, len(r.weights))
+	for member, weight := range r.weights {
+		m[member] = weight
+	}
+	return m
+}
+
+// Shares returns the fraction of keys owned by each member.
+func (r *Ring) Shares() map[string]float64 {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	return shares(r.points)
+}
+
+// hash is FNV-1a with a finalizer, similar inputs like virtual node names are
+// spread well.
+func hash(s string) uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	return uint32(x)
+}
+
+// search returns the index of the first point at or after h, wrapping around.
+func search(points []point, h uint32) int {
+	i := sort.Search(len(points), func(i int) bool { return points[i].hash >= h })
+	if i == len(points) {
+		i = 0
+	}
+	return i
+}
+
+const space = float64(1 << 32)
+
+// arc returns the length of hash space from the previous point to points[i].
+func arc(points []point, i int) float64 {
+	if i == 0 {
+		return float64(points[0].hash) + space - float64(points[len(points)-1].hash)
+	}
+	return float64(points[i].hash - points[i-1].hash)
+}
+
+func shares(points []point) map[string]float64 {
+	s := make(map[string]float64)
+	if len(points) == 1 {
+		s[points[0].member] = 1
+		return s
+	}
+	for i := range points {
+		s[points[i].member] += arc(points, i) / space
+	}
+	return s
+}
+
+// moved returns the fraction of hash space owned by different members.
+func moved(old, new []point) float64 {
+	if len(old) == 0 || len(new) == 0 {
+		if len(old) == len(new) {
+			return 0
+		}
+		return 1
+	}
+
+	// every segment between adjacent boundaries has a single owner in both.
+	bounds := make([]point, 0, len(old)+len(new))
+	bounds = append(bounds, old...)
+	bounds = append(bounds, new...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].hash < bounds[j].hash })
+
+	var m float64
+	for i := range bounds {
+		h := bounds[i].hash
+		if i > 0 && h == bounds[i-1].hash {
+			continue
+		}
+		if old[search(old, h)].member != new[search(new, h)].member {
+			m += arc(bounds, i) / space
+		}
+	}
+	return m
+}
@@ -0,0 +1,111 @@
+package ring
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+const keys = 10000
+
+func owners(r *Ring) []string {
+	o := make([]string, keys)
+	for i := range o {
+		o[i] = r.Get("key" + strconv.Itoa(i))
+	}
+	return o
+}
+
+func TestDistribution(t *testing.T) {
+	tests := []struct {
+		weights map[string]int
+		want    map[string]float64 // shares
+	}{
+		{map[string]int{"a": 1}, map[string]float64{"a": 1}},
+		{map[string]int{"a": 1, "b": 1, "c": 1}, map[string]float64{"a": 1.0 / 3, "b": 1.0 / 3, "c": 1.0 / 3}},
+		{map[string]int{"a": 1, "b": 3}, map[string]float64{"a": 0.25, "b": 0.75}},
+	}
+
+	for _, tt := range tests {
+		r := New(100)
+		for m, w := range tt.weights {
+			r.Set(m, w)
+		}
+
+		count := make(map[string]int)
+		for _, o := range owners(r) {
+			count[o]++
+		}
+		shares := r.Shares()
+		for m, want := range tt.want {
+			if got := float64(count[m]) / keys; math.Abs(got-want) > 0.05 {
+				t.Errorf("%v: %s owns %.3f of keys, want %.3f", tt.weights, m, got, want)
+			}
+			if math.Abs(shares[m]-want) > 0.05 {
+				t.Errorf("%v: Shares()[%s] = %.3f, want %.3f", tt.weights, m, shares[m], want)
+			}
+		}
+	}
+}
+
+func TestMoved(t *testing.T) {
+	r := New(100)
+	r.Set("a", 1)
+	r.Set("b", 1)
+	r.Set("c", 1)
+
+	// keys only move to the member added, or away from the member removed.
+	tests := []struct {
+		name   string
+		change func() Stats
+		member string
+	}{
+		{"add", func() Stats { return r.Set("d", 1) }, "d"},
+		{"grow", func() Stats { return r.Set("a", 2) }, "a"},
+		{"remove", func() Stats { return r.Remove("b") }, "b"},
+	}
+
+	for _, tt := range tests {
+		before := owners(r)
+		s := tt.change()
+		after := owners(r)
+
+		moved := 0
+		for i := range before {
+			if before[i] == after[i] {
+				continue
+			}
+			moved++
+			if before[i] != tt.member && after[i] != tt.member {
+				t.Fatalf("%s: key moved from %s to %s", tt.name, before[i], after[i])
+			}
+		}
+		if got := float64(moved) / keys; math.Abs(got-s.Moved) > 0.05 {
+			t.Errorf("%s: %.3f of keys moved, Stats.Moved = %.3f", tt.name, got, s.Moved)
+		}
+	}
+}
+
+func TestGetN(t *testing.T) {
+	r := New(10)
+	if m := r.Get("k"); m != "" {
+		t.Errorf("Get() = %q of an empty ring", m)
+	}
+	if m := r.GetN("k", 2); m != nil {
+		t.Errorf("GetN() = %q of an empty ring", m)
+	}
+
+	r.Set("a", 1)
+	r.Set("b", 1)
+	r.Set("c", 1)
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		m := r.GetN(key, 5)
+		if len(m) != 3 || m[0] != r.Get(key) {
+			t.Fatalf("GetN(%q, 5) = %q, want all members from %s", key, m, r.Get(key))
+		}
+		if m[0] == m[1] || m[1] == m[2] || m[0] == m[2] {
+			t.Fatalf("GetN(%q, 5) = %q, want distinct members", key, m)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package simulate
+
+import (
+	"container/list"
+
+	"github.com/huangml/proxycache/lru"
+	"github.com/huangml/proxycache/priority-queue/pq"
+)
+
+// LRU evicts the least-recently-used key, like cache.Cache.
+type LRU struct {
+	size int
+	keys map[string]struct{}
+	use  *lru.LRU
+}
+
+// NewLRU creates an LRU policy holding at most size keys.
+func NewLRU(size int) *LRU {
+	return &LRU{
+		size: size,
+		keys: make(map[string]struct{}),
+		use:  lru.New(),
+	}
+}
+
+// Access implements Policy.
+func (p *LRU) Access(key string) bool {
+	_, hit := p.keys[key]
+	p.keys[key] = struct{}{}
+	p.use.Touch(key)
+	for len(p.keys) > p.size {
+		delete(p.keys, p.use.Pop().(string))
+	}
+	return hit
+}
+
+// Len implements Policy.
+func (p *LRU) Len() int {
+	return len(p.keys)
+}
+
+// LFU evicts the least-frequently-used key, and the least-recently-used one
+// among keys of the same frequency.
+type LFU struct {
+	size  int
+	freq  map[string]int64
+	q     *pq.PriorityQueue
+	clock int64
+}
+
+// NewLFU creates an LFU policy holding at most size keys.
+func NewLFU(size int) *LFU {
+	return &LFU{
+		size: size,
+		freq: make(map[string]int64),
+		q:    pq.New(),
+	}
+}
+
+// lfuSeqBits is the bits of priority for recency of keys of the same frequency.
+const lfuSeqBits = 40
+
+// Access implements Policy.
+func (p *LFU) Access(key string) bool {
+	f, hit := p.freq[key]
+	if !hit && p.size <= 0 {
+		return false
+	}
+	if !hit && len(p.freq) >= p.size {
+		delete(p.freq, p.q.Pop().(string))
+	}
+
+	f++
+	p.freq[key] = f
+	p.clock++
+	p.q.Push(key, f<<lfuSeqBits|p.clock&(1<<lfuSeqBits-1))
+	return hit
+}
+
+// Len implements Policy.
+func (p *LFU) Len() int {
+	return len(p.freq)
+}
+
+// S3FIFO evicts keys by three FIFO queues: a small one for new keys, a main
+// one for keys accessed again, and a ghost one remembering keys recently
+// evicted from the small queue. Keys accessed only once, like a scan, leave
+// quickly from the small queue.
+type S3FIFO struct {
+	smallSize int
+	mainSize  int
+
+	small  *list.List
+	main   *list.List
+	ghost  *list.List
+	keys   map[string]*list.Element // in small or main
+	ghosts map[string]*list.Element
+}
+
+type s3Entry struct {
+	key  string
+	freq int
+}
+
+// NewS3FIFO creates an S3-FIFO policy holding at most size keys, 10% of which
+// are for the small queue.
+func NewS3FIFO(size int) *S3FIFO {
+	small := size / 10
+	if small < 1 && size > 1 {
+		small = 1
+	}
+	return &S3FIFO{
+		smallSize: small,
+		mainSize:  size - small,
+		small:     list.New(),
+		main:      list.New(),
+		ghost:     list.New(),
+		keys:      make(map[string]*list.Element),
+		ghosts:    make(map[string]*list.Element),
+	}
+}
+
+// Access implements Policy.
+func (p *S3FIFO) Access(key string) bool {
+	if e, ok := p.keys[key]; ok {
+		if s := e.Value.(*s3Entry); s.freq < 3 {
+			s.freq++
+		}
+		return true
+	}
+	if p.smallSize+p.mainSize <= 0 {
+		return false
+	}
+
+	// take the key out of ghost before evicting, which may push it out.
+	g, ghost := p.ghosts[key]
+	if ghost {
+		p.ghost.Remove(g)
+		delete(p.ghosts, key)
+	}
+	for len(p.keys) >= p.smallSize+p.mainSize {
+		p.evict()
+	}
+	if ghost {
+		p.keys[key] = p.main.PushBack(&s3Entry{key: key})
+	} else {
+		p.keys[key] = p.small.PushBack(&s3Entry{key: key})
+	}
+	return false
+}
+
+func (p *S3FIFO) evict() {
+	if p.small.Len() >= p.smallSize && p.small.Len() > 0 {
+		p.evictSmall()
+	} else {
+		p.evictMain()
+	}
+}
+
+func (p *S3FIFO) evictSmall() {
+	for p.small.Len() > 0 {
+		s := p.small.Remove(p.small.Front()).(*s3Entry)
+		if s.freq > 1 {
+			// accessed again, promote to main
+			s.freq = 0
+			p.keys[s.key] = p.main.PushBack(s)
+			if p.main.Len() > p.mainSize {
+				p.evictMain()
+				return
+			}
+			continue
+		}
+
+		delete(p.keys, s.key)
+		p.ghosts[s.key] = p.ghost.PushBack(s.key)
+		for p.ghost.Len() > p.mainSize {
+			delete(p.ghosts, p.ghost.Remove(p.ghost.Front()).(string))
+		}
+		return
+	}
+}
+
+func (p *S3FIFO) evictMain() {
+	for p.main.Len() > 0 {
+		s := p.main.Remove(p.main.Front()).(*s3Entry)
+		if s.freq > 0 {
+			s.freq--
+			p.keys[s.key] = p.main.PushBack(s)
+			continue
+		}
+		delete(p.keys, s.key)
+		return
+	}
+}
+
+// Len implements Policy.
+func (p *S3FIFO) Len() int {
+	return len(p.keys)
+}
@@ -0,0 +1,125 @@
+// package simulate replays access traces against eviction policies, so cache
+// sizes and policies can be chosen by hit ratios of real traffic.
+package simulate
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ErrUnknownPolicy is returned for a policy name not supported.
+var ErrUnknownPolicy = errors.New("simulate: unknown policy")
+
+// Policies are names of supported policies, see New.
+var Policies = []string{"lru", "lfu", "s3fifo"}
+
+// Access is an access to a key in a trace.
+type Access struct {
+	Key  string
+	Time time.Time
+}
+
+// Policy is a cache of keys, which evicts keys by its own policy.
+type Policy interface {
+	// Access accesses a key, and caches it if missed.
+	// It returns whether the key is hit.
+	Access(key string) (hit bool)
+	Len() int
+}
+
+// New creates a Policy by name, holding at most size keys.
+func New(policy string, size int) (Policy, error) {
+	switch policy {
+	case "lru":
+		return NewLRU(size), nil
+	case "lfu":
+		return NewLFU(size), nil
+	case "s3fifo":
+		return NewS3FIFO(size), nil
+	}
+	return nil, ErrUnknownPolicy
+}
+
+// ReadTrace reads a trace in CSV, one access a line, with the key in the first
+// column, and an optional unix epoch time in the second column, in seconds
+// with fractions.
+func ReadTrace(r io.Reader) ([]Access, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	var trace []Access
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return trace, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) == 0 || record[0] == "" {
+			continue
+		}
+
+		a := Access{Key: record[0]}
+		if len(record) > 1 && record[1] != "" {
+			sec, err := strconv.ParseFloat(record[1], 64)
+			if err != nil {
+				return nil, err
+			}
+			a.Time = time.Unix(0, int64(sec*float64(time.Second)))
+		}
+		trace = append(trace, a)
+	}
+}
+
+// Result is the result of replaying a trace against a policy.
+type Result struct {
+	Policy   string        `json:"policy"`
+	Size     int           `json:"size"`
+	Hits     int           `json:"hits"`
+	Misses   int           `json:"misses"`
+	HitRatio float64       `json:"hitRatio"`
+	Duration time.Duration `json:"duration"` // time span of the trace
+}
+
+// Replay replays a trace against a Policy.
+func Replay(trace []Access, p Policy) Result {
+	var r Result
+	for _, a := range trace {
+		if p.Access(a.Key) {
+			r.Hits++
+		} else {
+			r.Misses++
+		}
+	}
+	if n := r.Hits + r.Misses; n > 0 {
+		r.HitRatio = float64(r.Hits) / float64(n)
+	}
+	if len(trace) > 1 {
+		first, last := trace[0].Time, trace[len(trace)-1].Time
+		if !first.IsZero() && !last.IsZero() {
+			r.Duration = last.Sub(first)
+		}
+	}
+	return r
+}
+
+// Simulate replays a trace against every combination of policies and sizes.
+func Simulate(trace []Access, policies []string, sizes []int) ([]Result, error) {
+	var results []Result
+	for _, name := range policies {
+		for _, size := range sizes {
+			p, err := New(name, size)
+			if err != nil {
+				return nil, err
+			}
+			r := Replay(trace, p)
+			r.Policy, r.Size = name, size
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,65 @@
+package simulate
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadTrace(t *testing.T) {
+	trace, err := ReadTrace(strings.NewReader("a,1.5\n\nb\nc,3\n"))
+	if err != nil {
+		t.Fatalf("ReadTrace() = %v", err)
+	}
+	if len(trace) != 3 || trace[0].Key != "a" || trace[1].Key != "b" || trace[2].Key != "c" {
+		t.Fatalf("ReadTrace() = %v", trace)
+	}
+	if !trace[0].Time.Equal(time.Unix(1, int64(time.Second/2))) || !trace[1].Time.IsZero() {
+		t.Errorf("ReadTrace() times %v, %v", trace[0].Time, trace[1].Time)
+	}
+	if r := Replay(trace, NewLRU(1)); r.Duration != 1500*time.Millisecond {
+		t.Errorf("Replay() duration %v, want 1.5s", r.Duration)
+	}
+
+	if _, err := ReadTrace(strings.NewReader("a,x\n")); err == nil {
+		t.Error("ReadTrace() succeeded with a bad time")
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	if _, err := New("fifo", 1); err != ErrUnknownPolicy {
+		t.Fatalf("New(fifo) = %v, want %v", err, ErrUnknownPolicy)
+	}
+
+	var trace []Access
+	for i := 0; i < 1000; i++ {
+		// a hot key, and a scan of cold keys.
+		trace = append(trace, Access{Key: "hot"}, Access{Key: strconv.Itoa(i)})
+	}
+	for _, name := range Policies {
+		for _, size := range []int{1, 2, 10} {
+			p, err := New(name, size)
+			if err != nil {
+				t.Fatalf("New(%s) = %v", name, err)
+			}
+			for _, a := range trace {
+				p.Access(a.Key)
+				if p.Len() > size {
+					t.Fatalf("%s of size %d holds %d keys", name, size, p.Len())
+				}
+			}
+			if !p.Access("hot") && size > 1 {
+				t.Errorf("%s of size %d missed the hot key", name, size)
+			}
+		}
+	}
+
+	results, err := Simulate(trace, []string{"lru"}, []int{1, 2})
+	if err != nil {
+		t.Fatalf("Simulate() = %v", err)
+	}
+	if results[0].Hits != 0 || results[1].Hits != len(trace)/2-1 {
+		t.Errorf("Simulate() = %+v, want no hits of size 1, and hot keys hit of size 2", results)
+	}
+}
@@ -0,0 +1,284 @@
+// package sqlloader implements a ProxyLoader loading values by SQL queries.
+package sqlloader
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+
+	"github.com/huangml/proxycache/limiter"
+)
+
+// Keys is replaced by placeholders of keys in batch queries, see
+// SetBatchQuery.
+const Keys = "{keys}"
+
+// DefaultMaxBatch is the default maximum number of keys in one batch query,
+// see SetMaxBatch.
+const DefaultMaxBatch = 512
+
+// Loader loads the value of a key by a query with the key as its only
+// argument, like "SELECT value FROM t WHERE k = ?", which returns one row of
+// one column if the key exists.
+// It is a proxy.BatchProxyLoader, see SetBatchQuery.
+// Queries are prepared once, and run by at most maxConns connections of the
+// database at the same time, so loaders sharing a sql.DB don't starve others.
+type Loader struct {
+	db   *sql.DB
+	stmt *sql.Stmt
+
+	mtx         sync.Mutex
+	limit       *limiter.Limiter // nil if no limit
+	batchQuery  string
+	placeholder func(i int) string
+	maxBatch    int
+	batchStmts  map[int]*batchStmt // by the number of placeholders
+}
+
+// batchStmt is a prepared batch query. It is closed after the last use once
+// replaced, so batches running are not broken by SetBatchQuery.
+type batchStmt struct {
+	*sql.Stmt
+	n       int  // number of placeholders
+	uses    int  // batches running with it
+	retired bool // replaced, closed after the last use
+}
+
+// New creates a Loader of query, preparing it on db.
+// If maxConns is 0, the number of connections is not limited.
+func New(db *sql.DB, query string, maxConns int) (*Loader, error) {
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Loader{
+		db:          db,
+		stmt:        stmt,
+		placeholder: func(int) string { return "?" },
+		maxBatch:    DefaultMaxBatch,
+		batchStmts:  make(map[int]*batchStmt),
+	}
+	l.SetMaxConns(maxConns)
+	return l, nil
+}
+
+// SetBatchQuery sets the query loading multiple keys, like
+// "SELECT k, value FROM t WHERE k IN ({keys})", in which Keys is replaced by
+// placeholders of keys. It returns rows of two columns, the key and the value,
+// for keys exist.
+// Statements are prepared for numbers of keys rounded up to powers of 2, and
+// padded with the first key, so only a few of them are prepared. Batches
+// larger than maxBatch are split, see SetMaxBatch.
+// If query is empty, keys of a batch are loaded one by one.
+func (l *Loader) SetBatchQuery(query string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.batchQuery = query
+	l.closeBatchWithLock()
+}
+
+// SetPlaceholder sets the placeholder of the i-th argument of batch queries,
+// counting from 1, for drivers not using "?", like "$1" of PostgreSQL.
+func (l *Loader) SetPlaceholder(f func(i int) string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.placeholder = f
+	l.closeBatchWithLock()
+}
+
+// SetMaxBatch sets the maximum number of keys in one batch query, so queries
+// stay within the limit of placeholders of the database. If maxBatch is 0,
+// DefaultMaxBatch is used.
+func (l *Loader) SetMaxBatch(maxBatch int) {
+	if maxBatch <= 0 {
+		maxBatch = DefaultMaxBatch
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.maxBatch = maxBatch
+}
+
+// SetMaxConns sets the maximum number of connections running queries of
+// Loader. If maxConns is 0, there is no limit.
+func (l *Loader) SetMaxConns(maxConns int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	switch {
+	case maxConns <= 0:
+		l.limit = nil
+	case l.limit == nil:
+		l.limit = limiter.New(maxConns)
+	default:
+		l.limit.SetMax(maxConns)
+	}
+}
+
+// closeBatchWithLock retires the prepared batch queries, the ones in use are
+// closed by releaseStmt.
+func (l *Loader) closeBatchWithLock() {
+	for n, stmt := range l.batchStmts {
+		stmt.retired = true
+		if stmt.uses == 0 {
+			stmt.Close()
+		}
+		delete(l.batchStmts, n)
+	}
+}
+
+// Close closes the prepared statements.
+func (l *Loader) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.closeBatchWithLock()
+	return l.stmt.Close()
+}
+
+// acquire takes a connection of maxConns, it returns the function releasing
+// it.
+func (l *Loader) acquire() (release func()) {
+	l.mtx.Lock()
+	limit := l.limit
+	l.mtx.Unlock()
+
+	if limit == nil {
+		return func() {}
+	}
+	limit.Acquire()
+	return limit.Release
+}
+
+// Load loads the value of key.
+func (l *Loader) Load(key string) ([]byte, bool) {
+	return l.LoadContext(context.Background(), key)
+}
+
+// LoadContext loads the value of key, the query is canceled with ctx.
+func (l *Loader) LoadContext(ctx context.Context, key string) ([]byte, bool) {
+	defer l.acquire()()
+
+	var value []byte
+	if err := l.stmt.QueryRowContext(ctx, key).Scan(&value); err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// LoadBatch loads the values of keys by batch queries, see SetBatchQuery.
+func (l *Loader) LoadBatch(keys []string) ([][]byte, []bool) {
+	values := make([][]byte, len(keys))
+	oks := make([]bool, len(keys))
+
+	l.mtx.Lock()
+	maxBatch := l.maxBatch
+	l.mtx.Unlock()
+
+	for i := 0; i < len(keys); i += maxBatch {
+		j := i + maxBatch
+		if j > len(keys) {
+			j = len(keys)
+		}
+		l.loadBatch(keys[i:j], values[i:j], oks[i:j])
+	}
+	return values, oks
+}
+
+// loadBatch loads keys, at most maxBatch, by one batch query.
+func (l *Loader) loadBatch(keys []string, values [][]byte, oks []bool) {
+	stmt, err := l.batchStmt(len(keys))
+	if err != nil {
+		return
+	}
+	if stmt == nil {
+		for i, key := range keys {
+			values[i], oks[i] = l.Load(key)
+		}
+		return
+	}
+	defer l.releaseStmt(stmt)
+
+	args := make([]interface{}, stmt.n)
+	for i := range args {
+		args[i] = keys[0]
+		if i < len(keys) {
+			args[i] = keys[i]
+		}
+	}
+
+	defer l.acquire()()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	found := make(map[string][]byte, len(keys))
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return
+		}
+		found[key] = value
+	}
+	if rows.Err() != nil {
+		return
+	}
+
+	for i, key := range keys {
+		values[i], oks[i] = found[key]
+	}
+}
+
+// batchStmt returns the statement for k keys, which must be released by
+// releaseStmt. It returns nil if there is no batch query.
+func (l *Loader) batchStmt(k int) (*batchStmt, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.batchQuery == "" {
+		return nil, nil
+	}
+	n := 1
+	for n < k {
+		n *= 2
+	}
+	if n > l.maxBatch && k <= l.maxBatch {
+		n = l.maxBatch
+	}
+	stmt, ok := l.batchStmts[n]
+	if !ok {
+		placeholders := make([]string, n)
+		for i := range placeholders {
+			placeholders[i] = l.placeholder(i + 1)
+		}
+		query := strings.Replace(l.batchQuery, Keys, strings.Join(placeholders, ", "), -1)
+		prepared, err := l.db.Prepare(query)
+		if err != nil {
+			return nil, err
+		}
+		stmt = &batchStmt{Stmt: prepared, n: n}
+		l.batchStmts[n] = stmt
+	}
+	stmt.uses++
+	return stmt, nil
+}
+
+// releaseStmt ends a use of stmt, closing it if it is retired.
+func (l *Loader) releaseStmt(stmt *batchStmt) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	stmt.uses--
+	if stmt.retired && stmt.uses == 0 {
+		stmt.Close()
+	}
+}
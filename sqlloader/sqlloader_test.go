@@ -0,0 +1,192 @@
+package sqlloader
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is a database/sql driver serving queries of one column "k = ?", or
+// "k IN (...)", from a map.
+type fakeDB struct {
+	mtx      sync.Mutex
+	values   map[string]string
+	prepared []string // queries prepared
+	open     int      // statements not closed
+}
+
+func (d *fakeDB) Open(name string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.mtx.Lock()
+	defer c.d.mtx.Unlock()
+
+	c.d.prepared = append(c.d.prepared, query)
+	c.d.open++
+	return &fakeStmt{d: c.d, n: strings.Count(query, "?"), batch: strings.Contains(query, " IN ")}, nil
+}
+
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("no transactions") }
+
+type fakeStmt struct {
+	d     *fakeDB
+	n     int
+	batch bool
+}
+
+func (s *fakeStmt) Close() error {
+	s.d.mtx.Lock()
+	defer s.d.mtx.Unlock()
+
+	s.d.open--
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int { return s.n }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("no exec")
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mtx.Lock()
+	defer s.d.mtx.Unlock()
+
+	r := &fakeRows{batch: s.batch}
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		key := arg.(string)
+		if v, ok := s.d.values[key]; ok && !seen[key] {
+			seen[key] = true
+			r.rows = append(r.rows, [2]string{key, v})
+		}
+	}
+	return r, nil
+}
+
+type fakeRows struct {
+	batch bool
+	rows  [][2]string
+}
+
+func (r *fakeRows) Columns() []string {
+	if r.batch {
+		return []string{"k", "value"}
+	}
+	return []string{"value"}
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	if r.batch {
+		dest[0], dest[1] = row[0], []byte(row[1])
+	} else {
+		dest[0] = []byte(row[1])
+	}
+	return nil
+}
+
+var (
+	fake = &fakeDB{values: map[string]string{"a": "1", "b": "2", "c": "3"}}
+	once sync.Once
+)
+
+func newTestLoader(t *testing.T) *Loader {
+	once.Do(func() { sql.Register("sqlloadertest", fake) })
+	db, err := sql.Open("sqlloadertest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	l, err := New(db, "SELECT value FROM t WHERE k = ?", 2)
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestLoad(t *testing.T) {
+	l := newTestLoader(t)
+	if v, ok := l.Load("a"); !ok || string(v) != "1" {
+		t.Errorf("Load(a) = %q, %v", v, ok)
+	}
+	if v, ok := l.Load("x"); ok {
+		t.Errorf("Load(x) = %q of a key not exists", v)
+	}
+}
+
+func TestLoadBatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		maxBatch int
+		keys     []string
+		prepared []string
+	}{
+		{"one by one", "", 0, []string{"a", "x", "b"}, nil},
+		{"padded", "SELECT k, value FROM t WHERE k IN ({keys})", 0, []string{"a", "x", "b"},
+			[]string{"SELECT k, value FROM t WHERE k IN (?, ?, ?, ?)"}},
+		{"split", "SELECT k, value FROM t WHERE k IN ({keys})", 2, []string{"a", "x", "b"},
+			[]string{"SELECT k, value FROM t WHERE k IN (?, ?)", "SELECT k, value FROM t WHERE k IN (?)"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLoader(t)
+			l.SetBatchQuery(tt.query)
+			l.SetMaxBatch(tt.maxBatch)
+
+			fake.mtx.Lock()
+			fake.prepared = nil
+			fake.mtx.Unlock()
+
+			values, oks := l.LoadBatch(tt.keys)
+			if want := [][]byte{[]byte("1"), nil, []byte("2")}; !reflect.DeepEqual(values, want) ||
+				!reflect.DeepEqual(oks, []bool{true, false, true}) {
+				t.Errorf("LoadBatch() = %q, %v", values, oks)
+			}
+
+			fake.mtx.Lock()
+			prepared := fake.prepared
+			fake.mtx.Unlock()
+			if !reflect.DeepEqual(prepared, tt.prepared) {
+				t.Errorf("prepared %q, want %q", prepared, tt.prepared)
+			}
+		})
+	}
+}
+
+func TestClose(t *testing.T) {
+	fake.mtx.Lock()
+	open := fake.open
+	fake.mtx.Unlock()
+
+	l := newTestLoader(t)
+	l.SetBatchQuery("SELECT k, value FROM t WHERE k IN ({keys})")
+	l.LoadBatch([]string{"a", "b"})
+	l.SetBatchQuery("SELECT k, value FROM t WHERE k IN ({keys}) LIMIT 10")
+	l.LoadBatch([]string{"a", "b"})
+	l.Close()
+
+	fake.mtx.Lock()
+	defer fake.mtx.Unlock()
+	if fake.open != open {
+		t.Errorf("%d statements left open", fake.open-open)
+	}
+}
@@ -0,0 +1,163 @@
+// package timerwheel implements a hierarchical timer wheel.
+package timerwheel
+
+import (
+	"container/list"
+	"time"
+)
+
+const (
+	levels   = 4
+	slotBits = 6
+	slots    = 1 << slotBits
+	slotMask = slots - 1
+)
+
+// Wheel schedules values by their deadlines, with a resolution of tick.
+// Scheduling and removing are O(1), advancing is O(1) amortized per tick and
+// value, so it suits large number of timers.
+// Deadlines further than tick * 64^4 are capped, and scheduled again when they
+// get close enough.
+type Wheel struct {
+	tick  time.Duration
+	start time.Time
+	cur   uint64 // ticks advanced
+
+	wheel [levels][slots]*list.List
+	index map[interface{}]*list.Element
+}
+
+type timer struct {
+	value interface{}
+	at    uint64 // deadline in ticks
+	level int
+	slot  int
+}
+
+// New creates a Wheel starts at now.
+func New(tick time.Duration, now time.Time) *Wheel {
+	if tick <= 0 {
+		tick = time.Millisecond
+	}
+	w := &Wheel{
+		tick:  tick,
+		start: now,
+		index: make(map[interface{}]*list.Element),
+	}
+	for l := range w.wheel {
+		for s := range w.wheel[l] {
+			w.wheel[l][s] = list.New()
+		}
+	}
+	return w
+}
+
+// ticks converts t to ticks since start, rounded up so timers never fire
+// early.
+func (w *Wheel) ticks(t time.Time) uint64 {
+	d := t.Sub(w.start)
+	if d <= 0 {
+		return 0
+	}
+	return uint64((d + w.tick - 1) / w.tick)
+}
+
+// Schedule schedules a value at deadline, it replaces the previous schedule
+// of the value.
+func (w *Wheel) Schedule(value interface{}, deadline time.Time) {
+	w.Remove(value)
+	w.insert(&timer{value: value, at: w.ticks(deadline)})
+}
+
+func (w *Wheel) insert(t *timer) {
+	at := t.at
+	if at <= w.cur {
+		at = w.cur + 1
+	}
+
+	level := 0
+	delta := at - w.cur
+	for level < levels-1 && delta >= 1<<(slotBits*uint(level+1)) {
+		level++
+	}
+	if delta >= 1<<(slotBits*levels) {
+		at = w.cur + 1<<(slotBits*levels) - 1
+	}
+
+	t.level = level
+	t.slot = int(at>>(slotBits*uint(level))) & slotMask
+	w.index[t.value] = w.wheel[level][t.slot].PushBack(t)
+}
+
+// Remove removes a value from the wheel.
+func (w *Wheel) Remove(value interface{}) {
+	if e, ok := w.index[value]; ok {
+		t := e.Value.(*timer)
+		w.wheel[t.level][t.slot].Remove(e)
+		delete(w.index, value)
+	}
+}
+
+// Len returns the number of values scheduled.
+func (w *Wheel) Len() int {
+	return len(w.index)
+}
+
+// Advance moves the wheel to now, and returns values whose deadlines have
+// passed. They are removed from the wheel.
+func (w *Wheel) Advance(now time.Time) []interface{} {
+	var due []interface{}
+
+	target := w.ticks(now)
+	for w.cur < target {
+		if len(w.index) == 0 {
+			w.cur = target
+			break
+		}
+		w.cur++
+
+		// cascade timers of higher levels when level below wraps.
+		for level := 1; level < levels; level++ {
+			if w.cur&(1<<(slotBits*uint(level))-1) != 0 {
+				break
+			}
+			slot := int(w.cur>>(slotBits*uint(level))) & slotMask
+			due = w.cascade(level, slot, due)
+		}
+
+		for _, t := range w.detach(0, int(w.cur)&slotMask) {
+			if t.at <= w.cur {
+				delete(w.index, t.value)
+				due = append(due, t.value)
+			} else {
+				w.insert(t)
+			}
+		}
+	}
+	return due
+}
+
+// cascade moves timers of a slot to lower levels, timers due by now are
+// appended to due instead.
+func (w *Wheel) cascade(level, slot int, due []interface{}) []interface{} {
+	for _, t := range w.detach(level, slot) {
+		if t.at <= w.cur {
+			delete(w.index, t.value)
+			due = append(due, t.value)
+		} else {
+			w.insert(t)
+		}
+	}
+	return due
+}
+
+// detach empties a slot, and returns its timers.
+func (w *Wheel) detach(level, slot int) []*timer {
+	l := w.wheel[level][slot]
+	timers := make([]*timer, 0, l.Len())
+	for e := l.Front(); e != nil; e = e.Next() {
+		timers = append(timers, e.Value.(*timer))
+	}
+	l.Init()
+	return timers
+}
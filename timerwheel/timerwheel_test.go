@@ -0,0 +1,128 @@
+package timerwheel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/huangml/proxycache/clock"
+)
+
+const tick = time.Second
+
+// fired advances w by a tick at a time, up to n ticks, and returns the ticks
+// values fire at, counted from now.
+func fired(w *Wheel, clk *clock.Fake, n int) map[interface{}]int {
+	at := make(map[interface{}]int)
+	for i := 1; i <= n; i++ {
+		clk.Advance(tick)
+		for _, v := range w.Advance(clk.Now()) {
+			at[v] = i
+		}
+	}
+	return at
+}
+
+func TestWheelFires(t *testing.T) {
+	tests := []struct {
+		name     string
+		started  int           // ticks advanced at once before scheduling
+		deadline time.Duration // from now
+		want     int           // ticks to fire
+	}{
+		{"next tick", 0, tick, 1},
+		{"at deadline", 0, 5 * tick, 5},
+		{"rounded up", 0, 5*tick + 1, 6},
+		{"passed", 0, -tick, 1},
+		{"end of round", 0, 63 * tick, 63},
+		{"second round", 0, 64 * tick, 64},
+		{"second level", 0, 100 * tick, 100},
+		{"third level", 0, 5000 * tick, 5000},
+		{"fourth level", 0, 300000 * tick, 300000},
+		{"second round started", 70, 64 * tick, 64},
+		{"slot of current round", 70, 4090 * tick, 4090},
+		{"third level started", 4100, 4096 * tick, 4096},
+		{"fourth level started", 262200, 262144 * tick, 262144},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Unix(0, 0))
+			w := New(tick, clk.Now())
+			w.Schedule("keep", clk.Now().Add(time.Duration(1<<30)*tick))
+			clk.Advance(time.Duration(tt.started) * tick)
+			w.Advance(clk.Now())
+
+			w.Schedule("v", clk.Now().Add(tt.deadline))
+			if got := fired(w, clk, tt.want+1)["v"]; got != tt.want {
+				t.Errorf("fired after %d ticks, want %d", got, tt.want)
+			}
+			if w.Len() != 1 {
+				t.Errorf("Len() = %d after fired, want 1", w.Len())
+			}
+		})
+	}
+}
+
+func TestWheelAllDeadlines(t *testing.T) {
+	for _, started := range []int{0, 1, 63, 64, 70, 4095, 4100} {
+		clk := clock.NewFake(time.Unix(0, 0))
+		w := New(tick, clk.Now())
+		w.Schedule("keep", clk.Now().Add(time.Duration(1<<30)*tick))
+		clk.Advance(time.Duration(started) * tick)
+		w.Advance(clk.Now())
+
+		const n = 9000
+		for d := 1; d <= n; d++ {
+			w.Schedule(d, clk.Now().Add(time.Duration(d)*tick))
+		}
+		at := fired(w, clk, n)
+		for d := 1; d <= n; d++ {
+			if at[d] != d {
+				t.Fatalf("started %d: deadline of %d ticks fired after %d", started, d, at[d])
+			}
+		}
+	}
+}
+
+func TestWheelRemoveAndReschedule(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	w := New(tick, clk.Now())
+	w.Schedule("removed", clk.Now().Add(3*tick))
+	w.Schedule("later", clk.Now().Add(3*tick))
+	w.Schedule("earlier", clk.Now().Add(100*tick))
+	w.Remove("removed")
+	w.Remove("not scheduled")
+	w.Schedule("later", clk.Now().Add(200*tick))
+	w.Schedule("earlier", clk.Now().Add(2*tick))
+
+	if w.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", w.Len())
+	}
+	at := fired(w, clk, 300)
+	if _, ok := at["removed"]; ok {
+		t.Error("removed value fired")
+	}
+	if at["later"] != 200 || at["earlier"] != 2 {
+		t.Errorf("rescheduled values fired after %v, want later 200 and earlier 2", at)
+	}
+	if w.Len() != 0 {
+		t.Errorf("Len() = %d after all fired", w.Len())
+	}
+}
+
+func TestWheelAdvanceAtOnce(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	w := New(tick, clk.Now())
+	for _, d := range []int{1, 64, 5000} {
+		w.Schedule(d, clk.Now().Add(time.Duration(d)*tick))
+	}
+
+	clk.Advance(4999 * tick)
+	if due := w.Advance(clk.Now()); len(due) != 2 {
+		t.Fatalf("Advance() = %v, want 1 and 64", due)
+	}
+	clk.Advance(tick)
+	if due := w.Advance(clk.Now()); len(due) != 1 || due[0] != 5000 {
+		t.Fatalf("Advance() = %v, want 5000", due)
+	}
+}
@@ -0,0 +1,106 @@
+package proxycache
+
+import (
+	"errors"
+
+	"github.com/huangml/proxycache/cache"
+)
+
+var errTxnMissed = errors.New("proxycache: transaction missed keys")
+
+// Tx is a transaction on ProxyCache, see ProxyCache.Txn.
+type Tx struct {
+	p      *ProxyCache
+	tx     *cache.Tx
+	loaded map[string][]byte
+	missed []string
+	full   bool   // Buffer had no room for the keys put
+	admit  func() // releases the room reserved for the keys put
+}
+
+// Get retrieves data in transaction, data put in transaction are visible.
+// It returns nil if data is not found.
+func (tx *Tx) Get(key string) []byte {
+	if v, ok := tx.tx.Get(key); ok {
+		return v
+	}
+	if entry := tx.p.buffer.Get(key); entry != nil {
+		return entry.Value // nil for deletion
+	}
+	if v, ok := tx.loaded[key]; ok {
+		return v
+	}
+
+	// can't load with Cache locked, the transaction will be retried after
+	// loading.
+	tx.missed = append(tx.missed, key)
+	return nil
+}
+
+// Put puts data in transaction. Data will be visible to others, and saved
+// after the transaction is committed.
+func (tx *Tx) Put(key string, value []byte) {
+	tx.tx.Put(key, value)
+}
+
+// Txn calls f with a transaction, reads and writes in f are atomic with
+// respect to other operations on ProxyCache.
+// If f returns nil, all data put in transaction are committed and saved like
+// Put, otherwise they are discarded and the error is returned.
+// Data not in cache are loaded out of transaction, and then f is called again,
+// so f may be called more than once. f is called with Cache locked, so it
+// must not call ProxyCache's methods.
+// Room in Buffer is reserved for all data put at once. If there is no room,
+// and Buffer is blocking, Txn waits for it out of transaction, and then calls
+// f again.
+func (p *ProxyCache) Txn(f func(tx *Tx) error, ttw int64) error {
+	if _, err := p.admit(ttw); err != nil {
+		return err
+	}
+
+	loaded := make(map[string][]byte)
+	for {
+		tx := &Tx{p: p, loaded: loaded}
+		entries, err := p.cache.Txn(func(ctx *cache.Tx) error {
+			tx.tx = ctx
+			err := f(tx)
+			if len(tx.missed) > 0 {
+				return errTxnMissed
+			}
+			if err != nil || ttw == NoSave {
+				return err
+			}
+			// never wait for room with Cache locked.
+			tx.admit, err = p.buffer.TryAdmit(ctx.Keys()...)
+			tx.full = err != nil
+			return err
+		})
+
+		if len(tx.missed) > 0 {
+			for _, key := range tx.missed {
+				loaded[key] = p.Get(key)
+			}
+			continue
+		}
+		if tx.full {
+			// wait for room, then try again.
+			release, err := p.buffer.Admit(tx.tx.Keys()...)
+			if err != nil {
+				return err
+			}
+			release()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			p.save(entry, ttw)
+		}
+		if tx.admit != nil {
+			tx.admit()
+		}
+		return nil
+	}
+}